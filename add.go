@@ -3,7 +3,9 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 )
 
 // AddSubcommand handles the "add" subcommand to append a new command to the file.
@@ -12,10 +14,30 @@ func AddSubcommand() {
 	cmdPtr := addCmd.String("cmd", "", "The command to run")
 	namePtr := addCmd.String("name", "", "The name of the command")
 	descPtr := addCmd.String("desc", "", "A short description of the command")
+	cmdFilePtr := addCmd.String("cmd-file", "", "Read a (multi-line) command from a file, or - for stdin")
+	dirPtr := addCmd.String("dir", "", "The directory to run the command in, relative to the command file")
+	shellPtr := addCmd.String("shell", "", "The shell or interpreter to run the command with, or none to run it without a shell")
+	tagsPtr := addCmd.String("tags", "", "Comma-separated tags to find the command by")
+	groupPtr := addCmd.String("group", "", "The group to add the command to, under a [group] header")
+	confirmPtr := addCmd.String("confirm", "", "true to always ask before running the command, false to never ask")
+	var env, envFiles, needs listFlags
+	addCmd.Var(&env, "env", "Set an environment variable as NAME=value (repeatable)")
+	addCmd.Var(&envFiles, "env-file", "Load environment variables from a .env file, relative to the command file (repeatable)")
+	addCmd.Var(&needs, "needs", "The name of a command that must succeed before this one runs (repeatable)")
+	globalPtr := addCmd.Bool("global", false, "Add the command to the global user file instead of the project file")
 	addCmd.Parse(os.Args[2:])
 
+	if *cmdFilePtr != "" {
+		body, err := readCmdFile(*cmdFilePtr)
+		if err != nil {
+			fmt.Printf("%sError reading command file: %v%s\n", ColorRed, err, ColorReset)
+			os.Exit(1)
+		}
+		*cmdPtr = body
+	}
+
 	if *cmdPtr == "" || *namePtr == "" {
-		fmt.Println(ColorRed + "Error: --cmd and --name are required fields." + ColorReset)
+		fmt.Println(ColorRed + "Error: --cmd (or --cmd-file) and --name are required fields." + ColorReset)
 		addCmd.Usage()
 		os.Exit(1)
 	}
@@ -24,11 +46,87 @@ func AddSubcommand() {
 		Cmd:         *cmdPtr,
 		Name:        *namePtr,
 		Description: *descPtr,
+		Dir:         *dirPtr,
+		Env:         env,
+		EnvFiles:    envFiles,
+		Shell:       *shellPtr,
+		Needs:       needs,
+		Group:       strings.TrimSpace(*groupPtr),
+		Tags:        splitTags(*tagsPtr),
+	}
+	confirm, err := parseConfirm(*confirmPtr)
+	if err != nil {
+		fmt.Printf("%sError: %v%s\n", ColorRed, err, ColorReset)
+		os.Exit(1)
+	}
+	newCommand.Confirm = confirm
+	if err := checkCommon(newCommand); err != nil {
+		fmt.Printf("%sError: %v%s\n", ColorRed, err, ColorReset)
+		os.Exit(1)
 	}
 
-	if err := AppendCommand(newCommand); err != nil {
+	target := localCommandsFile(".")
+	if override := projectFileOverride(); override != "" {
+		target = override
+	}
+	if *globalPtr {
+		target = globalCommandsFile()
+	}
+	if err := checkNameFree(target, newCommand.Name); err != nil {
+		fmt.Printf("%sError: %v%s\n", ColorRed, err, ColorReset)
+		os.Exit(1)
+	}
+
+	if err := AppendCommandTo(target, newCommand); err != nil {
 		fmt.Printf("%sError adding command: %v%s\n", ColorRed, err, ColorReset)
 		os.Exit(1)
 	}
-	fmt.Println(ColorGreen + "Command added successfully!" + ColorReset)
+	fmt.Println(ColorGreen + "Command added successfully to " + target + "!" + ColorReset)
+}
+
+// checkNameFree fails when the commands file at path already has a command
+// called name. A missing file has none.
+func checkNameFree(path, name string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	commands, _ := formatForPath(path).loader.Decode(path, data)
+	for _, c := range commands {
+		if c.Name == name {
+			return fmt.Errorf("%s already has a command named %q; use 'aqc edit' to change it", path, name)
+		}
+	}
+	return nil
+}
+
+// readCmdFile returns the contents of path, or of stdin when path is "-",
+// without the trailing newline that editors add to the last line.
+func readCmdFile(path string) (string, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
+
+// listFlags collects the values of a repeatable flag in order.
+type listFlags []string
+
+func (l *listFlags) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlags) Set(value string) error {
+	*l = append(*l, value)
+	return nil
 }
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// attribute is a "key: value" line that a block can carry after its name line
+// from format version 2 on.
+type attribute struct {
+	key string
+	// set stores a value read from the file in c.
+	set func(c *Command, value string) error
+	// get returns the values to write for c, one line each; none when the
+	// attribute is not set.
+	get func(c Command) []string
+}
+
+// attributes lists the attributes in the order they are written.
+var attributes = []attribute{
+	{
+		key: "dir",
+		set: func(c *Command, value string) error {
+			if value == "" {
+				return fmt.Errorf("dir needs a directory")
+			}
+			c.Dir = value
+			return nil
+		},
+		get: func(c Command) []string { return nonEmpty(c.Dir) },
+	},
+	{
+		key: "env",
+		set: func(c *Command, value string) error {
+			if err := checkEnv([]string{value}); err != nil {
+				return err
+			}
+			c.Env = append(c.Env, value)
+			return nil
+		},
+		get: func(c Command) []string { return c.Env },
+	},
+	{
+		key: "env_file",
+		set: func(c *Command, value string) error {
+			if value == "" {
+				return fmt.Errorf("env_file needs a file")
+			}
+			c.EnvFiles = append(c.EnvFiles, value)
+			return nil
+		},
+		get: func(c Command) []string { return c.EnvFiles },
+	},
+	{
+		key: "shell",
+		set: func(c *Command, value string) error {
+			if err := checkShell(value); err != nil {
+				return err
+			}
+			c.Shell = value
+			return nil
+		},
+		get: func(c Command) []string { return nonEmpty(c.Shell) },
+	},
+	{
+		key: "needs",
+		set: func(c *Command, value string) error {
+			if value == "" {
+				return fmt.Errorf("needs needs the name of a command")
+			}
+			c.Needs = append(c.Needs, value)
+			return nil
+		},
+		get: func(c Command) []string { return c.Needs },
+	},
+	{
+		key: "tags",
+		set: func(c *Command, value string) error {
+			tags := splitTags(value)
+			if len(tags) == 0 {
+				return fmt.Errorf("tags needs at least one tag")
+			}
+			c.Tags = append(c.Tags, tags...)
+			return nil
+		},
+		get: func(c Command) []string {
+			if len(c.Tags) == 0 {
+				return nil
+			}
+			return []string{strings.Join(c.Tags, ", ")}
+		},
+	},
+	{
+		key: "confirm",
+		set: func(c *Command, value string) error {
+			confirm, err := parseConfirm(value)
+			if err == nil && confirm == nil {
+				err = fmt.Errorf("confirm needs true or false")
+			}
+			c.Confirm = confirm
+			return err
+		},
+		get: func(c Command) []string {
+			if c.Confirm == nil {
+				return nil
+			}
+			return []string{strconv.FormatBool(*c.Confirm)}
+		},
+	},
+}
+
+// nonEmpty returns s as the only value of an attribute, or no value when s is
+// empty.
+func nonEmpty(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return []string{s}
+}
+
+// parseAttribute applies a "key: value" attribute line to c. The value may be
+// a double-quoted string like the name and description.
+func parseAttribute(c *Command, line string) error {
+	key, value, ok := strings.Cut(line, ":")
+	key = strings.TrimSpace(key)
+	if !ok || key == "" || strings.ContainsAny(key, " \t") {
+		return fmt.Errorf("expected a \"key: value\" attribute, got %q", line)
+	}
+	for _, a := range attributes {
+		if a.key == key {
+			return a.set(c, unquoteField(strings.TrimSpace(value)))
+		}
+	}
+	return fmt.Errorf("unknown attribute %q", key)
+}
+
+// attributeLines renders the attributes set on c as lines of its block.
+func attributeLines(c Command) []string {
+	var lines []string
+	for _, a := range attributes {
+		for _, value := range a.get(c) {
+			lines = append(lines, a.key+": "+encodeField(value, false))
+		}
+	}
+	return lines
+}
+
+// requiredVersion returns the oldest format version that can hold c.
+func requiredVersion(c Command) int {
+	if len(attributeLines(c)) > 0 || c.Group != "" {
+		return 2
+	}
+	return 1
+}
+
+// parseConfirm reads the value of a confirm attribute or flag. An empty value
+// leaves it unset.
+func parseConfirm(value string) (*bool, error) {
+	if value == "" {
+		return nil, nil
+	}
+	confirm, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, fmt.Errorf("confirm must be true or false, not %q", value)
+	}
+	return &confirm, nil
+}
+
+// splitTags splits a comma-separated list of tags, dropping empty ones.
+func splitTags(value string) []string {
+	var tags []string
+	for _, tag := range strings.Split(value, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// checkTag reports whether tag can be written to a tags attribute.
+func checkTag(tag string) error {
+	if tag == "" || strings.TrimSpace(tag) != tag || strings.ContainsAny(tag, ",\n") {
+		return fmt.Errorf("invalid tag %q", tag)
+	}
+	return nil
+}
+
+// hasTag reports whether c carries tag, either as one of its tags or as its
+// group. Case is ignored.
+func hasTag(c Command, tag string) bool {
+	if strings.EqualFold(c.Group, tag) {
+		return true
+	}
+	for _, t := range c.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// groupName returns the name of the group that line starts, as in
+// "[deploy]", or "" when the line is not a group header.
+func groupName(line string) string {
+	line = strings.TrimSpace(line)
+	if len(line) < 2 || line[0] != '[' || line[len(line)-1] != ']' {
+		return ""
+	}
+	// "[ -f x ]" is a shell test rather than a header.
+	name := line[1 : len(line)-1]
+	if strings.TrimSpace(name) != name || strings.ContainsAny(name, "[]") {
+		return ""
+	}
+	return name
+}
+
+// checkGroup reports whether name can be written as a group header.
+func checkGroup(name string) error {
+	if groupName("["+name+"]") != name || strings.Contains(name, "\n") {
+		return fmt.Errorf("invalid group name %q", name)
+	}
+	return nil
+}
+
+// defaultsMarker is the first line of a block that sets attributes for every
+// command of its file instead of describing a command.
+const defaultsMarker = "@defaults"
+
+// defaultKeys lists the attributes a @defaults block may set.
+var defaultKeys = []string{"env", "env_file", "shell"}
+
+// Defaults holds the attributes set by the @defaults block of a command file.
+// They apply to every command of the file; a command's own attributes are
+// applied after them.
+type Defaults struct {
+	Env      []string
+	EnvFiles []string
+	Shell    string
+}
+
+// isDefaultsBlock reports whether b is a @defaults block.
+func isDefaultsBlock(b block) bool {
+	return strings.TrimSpace(b.lines[0]) == defaultsMarker
+}
+
+// parseDefaults reads the attribute lines of a @defaults block. Lines that are
+// not attributes a @defaults block may set are reported and ignored.
+func parseDefaults(b block) (*Defaults, []Diagnostic) {
+	var c Command
+	var diags []Diagnostic
+	for i := 1; i < len(b.lines); i++ {
+		line := strings.TrimSpace(b.lines[i])
+		key, _, _ := strings.Cut(line, ":")
+		if !slices.Contains(defaultKeys, strings.TrimSpace(key)) {
+			diags = append(diags, blockDiag(b, i, fmt.Sprintf("%s can only set %s; line ignored", defaultsMarker, strings.Join(defaultKeys, ", "))))
+			continue
+		}
+		if err := parseAttribute(&c, line); err != nil {
+			diags = append(diags, blockDiag(b, i, err.Error()+"; line ignored"))
+		}
+	}
+	return &Defaults{Env: c.Env, EnvFiles: c.EnvFiles, Shell: c.Shell}, diags
+}
+
+// encodeDefaults renders the lines of a @defaults block without a separator
+// or trailing newline.
+func encodeDefaults(d Defaults) string {
+	lines := append([]string{defaultsMarker}, attributeLines(Command{Env: d.Env, EnvFiles: d.EnvFiles, Shell: d.Shell})...)
+	return strings.Join(lines, "\n")
+}
+
+// resolvePath returns path as seen from c's file: ~ and environment variables
+// are expanded and a relative path is taken from the directory of the file.
+func resolvePath(c Command, path string) string {
+	path = expandPath(path)
+	if filepath.IsAbs(path) || c.Source == "" {
+		return path
+	}
+	return filepath.Join(filepath.Dir(c.Source), path)
+}
+
+// workDir returns the directory c runs in. A dir attribute may use ~ and
+// environment variables and is relative to the directory of c's file.
+// Without one, project commands run in their file's directory and global
+// commands in the current directory.
+func workDir(c Command) string {
+	if c.Dir == "" {
+		if c.Global || c.Source == "" {
+			return ""
+		}
+		return filepath.Dir(c.Source)
+	}
+	return resolvePath(c, c.Dir)
+}
+
+// expandPath expands environment variables and a leading ~ in path.
+func expandPath(path string) string {
+	path = os.ExpandEnv(path)
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[1:])
+		}
+	}
+	return path
+}
+
+// splitEnv splits a NAME=value environment entry.
+func splitEnv(entry string) (name, value string, err error) {
+	name, value, ok := strings.Cut(entry, "=")
+	if !ok {
+		return "", "", fmt.Errorf("expected NAME=value, got %q", entry)
+	}
+	if !isEnvName(name) {
+		return "", "", fmt.Errorf("invalid variable name %q", name)
+	}
+	return name, value, nil
+}
+
+// checkEnv checks that each entry of env is a NAME=value entry.
+func checkEnv(env []string) error {
+	for _, entry := range env {
+		if _, _, err := splitEnv(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isEnvName reports whether name can be used as an environment variable name
+// in a shell.
+func isEnvName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// commandEnv returns the environment c runs with: the environment of aqc, then
+// the env files and env entries of the file's @defaults block, then those of
+// c itself. Later values win. ${NAME} in a value is replaced by the value NAME
+// has at that point, so an entry can build on the ones before it.
+func commandEnv(c Command) ([]string, error) {
+	env := os.Environ()
+	values := make(map[string]string, len(env))
+	for _, entry := range env {
+		if name, value, ok := strings.Cut(entry, "="); ok {
+			values[name] = value
+		}
+	}
+	expand := func(s string) string {
+		return os.Expand(s, func(name string) string { return values[name] })
+	}
+	set := func(name, value string) {
+		values[name] = value
+		env = append(env, name+"="+value)
+	}
+	apply := func(entries, files []string) error {
+		for _, file := range files {
+			if err := readEnvFile(resolvePath(c, file), expand, set); err != nil {
+				return err
+			}
+		}
+		for _, entry := range entries {
+			name, value, err := splitEnv(entry)
+			if err != nil {
+				return err
+			}
+			set(name, expand(value))
+		}
+		return nil
+	}
+
+	if d := c.Defaults; d != nil {
+		if err := apply(d.Env, d.EnvFiles); err != nil {
+			return nil, err
+		}
+	}
+	if err := apply(c.Env, c.EnvFiles); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// readEnvFile reads a .env file and passes each variable it sets to set, in
+// order. Lines hold NAME=value, optionally preceded by "export"; blank lines
+// and lines starting with # are skipped. Values may be double-quoted Go
+// strings or single-quoted literal text; ${NAME} is expanded with expand
+// except in single-quoted values, and a " #" ends an unquoted value.
+func readEnvFile(path string, expand func(string) string, set func(name, value string)) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		name, value, err := splitEnv(line)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", path, i+1, err)
+		}
+		value = strings.TrimSpace(value)
+		switch {
+		case strings.HasPrefix(value, "'"):
+			if len(value) < 2 || !strings.HasSuffix(value, "'") {
+				return fmt.Errorf("%s:%d: unterminated ' quote", path, i+1)
+			}
+			value = value[1 : len(value)-1]
+		case strings.HasPrefix(value, `"`):
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return fmt.Errorf("%s:%d: invalid quoted value %s", path, i+1, value)
+			}
+			value = expand(unquoted)
+		default:
+			if j := strings.Index(value, " #"); j >= 0 {
+				value = strings.TrimSpace(value[:j])
+			}
+			value = expand(value)
+		}
+		set(name, value)
+	}
+	return nil
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestDirAttribute(t *testing.T) {
+	content := "#aqc v2\nnpm run build\n- Build frontend\ndir: web\n---\nmake\n- Build: Everything\ndir: \" spaced \"\n---\n"
+	commands, diags := ParseCommandFile("f", content)
+	if len(diags) != 0 {
+		t.Fatalf("Unexpected diagnostics: %v", diags)
+	}
+	if commands[0].Dir != "web" || commands[1].Dir != " spaced " {
+		t.Errorf("Dirs = %q, %q; expected \"web\", \" spaced \"", commands[0].Dir, commands[1].Dir)
+	}
+	if commands[0].EndLine != 4 {
+		t.Errorf("EndLine = %d, expected 4", commands[0].EndLine)
+	}
+
+	back, diags := ParseCommandFile("f", formatBlock(commands[1]))
+	if len(diags) != 1 || back[0].Dir != "" {
+		t.Errorf("A v1 file read the dir attribute: %+v (%v)", back, diags)
+	}
+	back, diags = ParseCommandFile("f", formatHeader(2)+"\n"+formatBlock(commands[1]))
+	if len(diags) != 0 || !sameCommands(back, commands[1:]) {
+		t.Errorf("Round trip = %+v (%v), expected %+v", back, diags, commands[1:])
+	}
+
+	if _, diags := ParseCommandFile("f", "#aqc v2\nls\n- List\ndir:\n"); len(diags) != 1 {
+		t.Errorf("An empty dir gave diagnostics %v, expected one", diags)
+	}
+}
+
+func TestWorkDir(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip("no home directory")
+	}
+	t.Setenv("AQC_TEST_DIR", "/srv/app")
+	source := filepath.Join("repo", "sub", commandsFile)
+
+	tests := []struct {
+		name     string
+		command  Command
+		expected string
+	}{
+		{"file directory", Command{Source: source}, filepath.Join("repo", "sub")},
+		{"global without dir", Command{Source: "/cfg/commands.aqc", Global: true}, ""},
+		{"relative dir", Command{Source: source, Dir: "../web"}, filepath.Join("repo", "web")},
+		{"absolute dir", Command{Source: source, Dir: "/tmp"}, "/tmp"},
+		{"home dir", Command{Source: source, Dir: "~/src"}, filepath.Join(home, "src")},
+		{"environment variable", Command{Source: source, Dir: "$AQC_TEST_DIR/logs"}, "/srv/app/logs"},
+		{"global with dir", Command{Source: "/cfg/commands.aqc", Global: true, Dir: "scripts"}, "/cfg/scripts"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := workDir(tt.command); got != tt.expected {
+				t.Errorf("workDir() = %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRunCommandUsesDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "web"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	result := RunCommand(Command{Cmd: "pwd > out", Dir: "web", Source: filepath.Join(dir, commandsFile)})
+	if !result.Success() {
+		t.Fatalf("RunCommand() = %s", result)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "web", "out")); err != nil {
+		t.Errorf("Command did not run in the dir: %v", err)
+	}
+
+	result = RunCommand(Command{Cmd: "true", Dir: "missing", Source: filepath.Join(dir, commandsFile)})
+	if result.Success() || result.Err == nil {
+		t.Errorf("RunCommand() in a missing dir = %s, expected an error", result)
+	}
+}
+
+func TestAppendCommandWithAttributes(t *testing.T) {
+	c := Command{Cmd: "npm test", Name: "Test", Dir: "web"}
+
+	t.Run("new file gets a header", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), commandsFile)
+		if err := AppendCommandTo(path, c); err != nil {
+			t.Fatalf("AppendCommandTo() error = %v", err)
+		}
+		data, _ := os.ReadFile(path)
+		if !strings.HasPrefix(string(data), "#aqc v2\n") {
+			t.Errorf("File = %q, expected a v2 header", data)
+		}
+		if commands, _ := ParseCommandFile(path, string(data)); len(commands) != 1 || commands[0].Dir != "web" {
+			t.Errorf("Commands = %+v", commands)
+		}
+	})
+
+	t.Run("new file gets a header without attributes", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), commandsFile)
+		if err := AppendCommandTo(path, Command{Cmd: "ls", Name: "List"}); err != nil {
+			t.Fatalf("AppendCommandTo() error = %v", err)
+		}
+		if err := AppendCommandTo(path, c); err != nil {
+			t.Fatalf("AppendCommandTo() with attributes error = %v", err)
+		}
+		data, _ := os.ReadFile(path)
+		if !strings.HasPrefix(string(data), "#aqc v2\n") {
+			t.Errorf("File = %q, expected a v2 header", data)
+		}
+		if commands, _ := ParseCommandFile(path, string(data)); len(commands) != 2 || commands[1].Dir != "web" {
+			t.Errorf("Commands = %+v", commands)
+		}
+	})
+
+	t.Run("v1 file is not changed", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), commandsFile)
+		if err := os.WriteFile(path, []byte("ls\n- List\n---\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := AppendCommandTo(path, c); err == nil || !strings.Contains(err.Error(), "aqc migrate") {
+			t.Errorf("AppendCommandTo() error = %v, expected a hint to migrate", err)
+		}
+		if data, _ := os.ReadFile(path); string(data) != "ls\n- List\n---\n" {
+			t.Errorf("File changed to %q", data)
+		}
+	})
+}
+
+func TestEnvAttributes(t *testing.T) {
+	content := "#aqc v2\nnpm start\n- Start\nenv: NODE_ENV=development\nenv: \"GREETING= hi \"\nenv_file: .env\n---\n"
+	commands, diags := ParseCommandFile("f", content)
+	if len(diags) != 0 || len(commands) != 1 {
+		t.Fatalf("ParseCommandFile() = %+v, %v", commands, diags)
+	}
+	c := commands[0]
+	if !reflect.DeepEqual(c.Env, []string{"NODE_ENV=development", "GREETING= hi "}) || !reflect.DeepEqual(c.EnvFiles, []string{".env"}) {
+		t.Errorf("Env = %q, EnvFiles = %q", c.Env, c.EnvFiles)
+	}
+	if got := formatHeader(2) + "\n" + formatBlock(c); got != content {
+		t.Errorf("formatBlock() = %q, expected %q", got, content)
+	}
+
+	for _, line := range []string{"env: NOVALUE", "env: 1X=y", "env: =y", "env_file:"} {
+		if _, diags := ParseCommandFile("f", "#aqc v2\nls\n- List\n"+line+"\n"); len(diags) != 1 {
+			t.Errorf("%q gave diagnostics %v, expected one", line, diags)
+		}
+	}
+}
+
+func TestTagsAttribute(t *testing.T) {
+	content := "#aqc v2\nkubectl apply\n- Deploy\ntags: prod, k8s\ntags: ci\n---\n"
+	commands, diags := ParseCommandFile("f", content)
+	if len(diags) != 0 || len(commands) != 1 {
+		t.Fatalf("ParseCommandFile() = %+v, %v", commands, diags)
+	}
+	c := commands[0]
+	if !reflect.DeepEqual(c.Tags, []string{"prod", "k8s", "ci"}) {
+		t.Errorf("Tags = %q", c.Tags)
+	}
+	if got := formatBlock(c); got != "kubectl apply\n- Deploy\ntags: prod, k8s, ci\n---\n" {
+		t.Errorf("formatBlock() = %q", got)
+	}
+	if _, diags := ParseCommandFile("f", "#aqc v2\nls\n- List\ntags: ,\n"); len(diags) != 1 {
+		t.Errorf("An empty tags line gave diagnostics %v, expected one", diags)
+	}
+
+	for _, tag := range []string{"PROD", "ci"} {
+		if !hasTag(c, tag) {
+			t.Errorf("hasTag(%q) = false", tag)
+		}
+	}
+	if hasTag(c, "dev") || !hasTag(Command{Group: "Deploy"}, "deploy") {
+		t.Error("hasTag() does not match groups and tags only")
+	}
+}
+
+func TestGroupName(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected string
+	}{
+		{"[deploy]", "deploy"},
+		{"  [Data base]  ", "Data base"},
+		{"[ -f .env ]", ""},
+		{"[[ -n $X ]]", ""},
+		{"[]", ""},
+		{"[a] && b", ""},
+	}
+	for _, tt := range tests {
+		if got := groupName(tt.line); got != tt.expected {
+			t.Errorf("groupName(%q) = %q, expected %q", tt.line, got, tt.expected)
+		}
+	}
+	if c := (Command{Name: "Check", Cmd: "[ck]"}); formatBlock(c) != "```\n[ck]\n```\n- Check\n---\n" {
+		t.Errorf("A command that looks like a group header was not fenced: %q", formatBlock(c))
+	}
+}
+
+func TestDefaultsBlock(t *testing.T) {
+	content := "#aqc v2\n# Shared settings\n@defaults\nenv: A=1\nenv_file: .env\n---\nls\n- List\n---\n"
+	doc, diags := ParseDocument("f", content)
+	if len(diags) != 0 {
+		t.Fatalf("Unexpected diagnostics: %v", diags)
+	}
+	commands := doc.Commands()
+	if len(commands) != 1 || commands[0].Defaults == nil {
+		t.Fatalf("Commands() = %+v, expected one command with defaults", commands)
+	}
+	if d := commands[0].Defaults; !reflect.DeepEqual(d.Env, []string{"A=1"}) || !reflect.DeepEqual(d.EnvFiles, []string{".env"}) {
+		t.Errorf("Defaults = %+v", d)
+	}
+	if doc.String() != content {
+		t.Errorf("String() = %q, expected the file unchanged", doc.String())
+	}
+
+	t.Run("bad lines", func(t *testing.T) {
+		_, diags := ParseDocument("f", "#aqc v2\n@defaults\ndir: web\n---\n@defaults\nenv: B=2\n---\n")
+		if len(diags) != 2 {
+			t.Errorf("Diagnostics = %v, expected a dir line and a second block", diags)
+		}
+	})
+	t.Run("v1 has no defaults", func(t *testing.T) {
+		commands, _ := ParseCommandFile("f", "@defaults\n- Literal\n---\n")
+		if len(commands) != 1 || commands[0].Cmd != defaultsMarker {
+			t.Errorf("Commands = %+v, expected a command named after the marker", commands)
+		}
+		encoded := formatHeader(2) + "\n" + formatBlock(commands[0])
+		if back, diags := ParseCommandFile("f", encoded); len(diags) != 0 || len(back) != 1 || back[0].Cmd != defaultsMarker {
+			t.Errorf("%q read back as %+v (%v)", encoded, back, diags)
+		}
+	})
+	t.Run("sort keeps it first", func(t *testing.T) {
+		doc, _ := ParseDocument("f", "#aqc v2\n@defaults\nenv: A=1\n---\nzip\n- Zip\n---\nls\n- List\n---\n")
+		doc.Sort(byName)
+		if want := "#aqc v2\n@defaults\nenv: A=1\n---\nls\n- List\n---\nzip\n- Zip\n---\n"; doc.String() != want {
+			t.Errorf("Sorted document = %q, expected %q", doc.String(), want)
+		}
+	})
+}
+
+func TestCommandEnv(t *testing.T) {
+	dir := t.TempDir()
+	envFile := "# settings\nexport FROM_FILE=file\nQUOTED=\"a\\tb\"\nLITERAL='${HOME}'\nPLAIN=x${FROM_FILE} # comment\n"
+	if err := os.WriteFile(filepath.Join(dir, ".env"), []byte(envFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AQC_TEST_BASE", "base")
+	c := Command{
+		Source:   filepath.Join(dir, commandsFile),
+		Env:      []string{"LAYER=command", "JOINED=${AQC_TEST_BASE}-${DEFAULT}-${LAYER}"},
+		EnvFiles: []string{".env"},
+		Defaults: &Defaults{Env: []string{"LAYER=defaults", "DEFAULT=d"}},
+	}
+	env, err := commandEnv(c)
+	if err != nil {
+		t.Fatalf("commandEnv() error = %v", err)
+	}
+	values := make(map[string]string)
+	for _, entry := range env {
+		name, value, _ := strings.Cut(entry, "=")
+		values[name] = value
+	}
+	expected := map[string]string{
+		"AQC_TEST_BASE": "base",
+		"FROM_FILE":     "file",
+		"QUOTED":        "a\tb",
+		"LITERAL":       "${HOME}",
+		"PLAIN":         "xfile",
+		"LAYER":         "command",
+		"DEFAULT":       "d",
+		"JOINED":        "base-d-command",
+	}
+	for name, value := range expected {
+		if values[name] != value {
+			t.Errorf("%s = %q, expected %q", name, values[name], value)
+		}
+	}
+
+	if _, err := commandEnv(Command{Source: c.Source, EnvFiles: []string{"missing.env"}}); err == nil {
+		t.Error("commandEnv() with a missing env file succeeded")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "bad.env"), []byte("OK=1\nnot a variable\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := commandEnv(Command{Source: c.Source, EnvFiles: []string{"bad.env"}}); err == nil || !strings.Contains(err.Error(), "bad.env:2") {
+		t.Errorf("commandEnv() error = %v, expected one pointing at bad.env:2", err)
+	}
+}
+
+func TestRunCommandUsesEnv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+
+	dir := t.TempDir()
+	c := Command{Cmd: `test "$GREETING" = hello`, Env: []string{"GREETING=hello"}, Source: filepath.Join(dir, commandsFile)}
+	if result := RunCommand(c); !result.Success() {
+		t.Errorf("RunCommand() = %s, expected the variable to be set", result)
+	}
+	c.EnvFiles = []string{"missing.env"}
+	if result := RunCommand(c); result.Err == nil {
+		t.Errorf("RunCommand() with a missing env file = %s, expected an error", result)
+	}
+}
@@ -2,107 +2,551 @@ package main
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
 )
 
 const commandsFile = ".commands.aqc"
 
-// Command holds the shell command, its display name, and a short description.
+// fence opens and closes a multi-line command body inside a block. A fence
+// may be longer than three backticks; the closing fence must then match the
+// opening one exactly, so bodies can themselves contain ``` lines.
+const fence = "```"
+
+// Command is a command read from a commands file: the shell command, its
+// display name and a short description, with the settings of its block.
 type Command struct {
 	Cmd         string
 	Name        string
 	Description string
+
+	// Dir is the directory the command runs in, as written in its file (see
+	// workDir).
+	Dir string
+	// Env holds NAME=value entries and EnvFiles .env files to load before
+	// running it (see commandEnv).
+	Env      []string
+	EnvFiles []string
+	// Shell is the shell or interpreter that runs it (see commandArgv).
+	Shell string
+	// Needs names the commands that must succeed before it runs (see
+	// planRun).
+	Needs []string
+	// Group is the section of the file the command is in and Tags are
+	// labels; both organize the menu and filter commands.
+	Group string
+	Tags  []string
+	// Confirm, when set, says whether running it must be confirmed first,
+	// instead of guessing (see confirmReason).
+	Confirm *bool
+	// Defaults is the @defaults block of its file, if any.
+	Defaults *Defaults
+
+	// Source is the path of the file the command was read from, and Global
+	// is set for commands from the user-level file.
+	Source string
+	Global bool
+	// Line and EndLine are the 1-based first and last lines of the command's
+	// block in Source; for YAML, TOML and JSON files both hold the position
+	// of the command's entry instead.
+	Line    int
+	EndLine int
+}
+
+// Diagnostic describes a problem found while parsing a commands file.
+// Line and Column are 1-based; Line is 0 when the problem has no position in
+// the file.
+type Diagnostic struct {
+	File   string
+	Line   int
+	Column int
+	Reason string
+}
+
+// String formats the diagnostic as file:line:column: reason.
+func (d Diagnostic) String() string {
+	if d.Line == 0 {
+		return fmt.Sprintf("%s: %s", d.File, d.Reason)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Column, d.Reason)
 }
 
-// LoadCommands reads the commands file, parses its content, and returns a slice of Command.
-func LoadCommands() []Command {
-	if _, err := os.Stat(commandsFile); os.IsNotExist(err) {
-		fmt.Printf("%sError: %s not found in the current directory.%s\n", ColorRed, commandsFile, ColorReset)
+// LoadCommands reads the project commands files (the current directory and its
+// parents, or the --file/AQC_FILE override) and the global user file, parses
+// their content, and returns a slice of Command along with any problems found
+// in the files.
+func LoadCommands() ([]Command, []Diagnostic) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		fmt.Printf("%sError: %v%s\n", ColorRed, err, ColorReset)
 		os.Exit(1)
 	}
-	data, err := ioutil.ReadFile(commandsFile)
+	files := projectCommandFiles(cwd)
+	global := globalCommandsFile()
+	if len(files) == 0 {
+		if _, err := os.Stat(global); err != nil {
+			fmt.Printf("%sError: %s not found in the current directory or its parents.%s\n", ColorRed, commandsFile, ColorReset)
+			os.Exit(1)
+		}
+	}
+	commands, diags, err := loadCommandFiles(files, global)
 	if err != nil {
 		fmt.Printf("%sError reading file: %v%s\n", ColorRed, err, ColorReset)
 		os.Exit(1)
 	}
-	blocks := parseBlocks(string(data))
-	return parseCommands(blocks)
+	return commands, diags
 }
 
-// parseBlocks splits the file content into separate command blocks.
-// Blocks are separated by a line containing exactly "---".
-func parseBlocks(data string) []string {
-	var blocks []string
+// ParseCommandFile parses the content of a commands file. Blocks that cannot
+// be turned into a Command are skipped and reported as diagnostics.
+func ParseCommandFile(file, data string) ([]Command, []Diagnostic) {
+	doc, diags := ParseDocument(file, data)
+	return doc.Commands(), diags
+}
+
+// formatVersion is the newest command file format this version of aqc reads
+// and writes. Files without a header are version 1.
+const formatVersion = 2
+
+// headerPrefix starts the optional header line that declares a file's format
+// version, as in "#aqc v2".
+const headerPrefix = "#aqc"
+
+// formatHeader returns the header line declaring the given format version.
+func formatHeader(version int) string {
+	return fmt.Sprintf("%s v%d", headerPrefix, version)
+}
+
+// splitHeader reads the format version from the header on the first non-blank
+// line of data and returns the data with the header line blanked out, so line
+// numbers stay the same. Only a line of the form "#aqc v<N>" is a header; any
+// other first line, such as "#aqc commands for this repo", is left to be read
+// as a comment. Files without a header are version 1. Versions newer than
+// formatVersion are rejected; the error comes with the header's line number.
+func splitHeader(data string) (version int, body string, line int, err error) {
 	lines := strings.Split(data, "\n")
-	var currentBlock []string
-	for _, line := range lines {
-		trimmed := strings.TrimSpace(line)
-		if trimmed == "---" {
-			if len(currentBlock) > 0 {
-				blocks = append(blocks, strings.Join(currentBlock, "\n"))
-				currentBlock = []string{}
-			}
-		} else if trimmed != "" {
-			currentBlock = append(currentBlock, line)
+	i := 0
+	for i < len(lines)-1 && strings.TrimSpace(lines[i]) == "" {
+		i++
+	}
+	fields := strings.Fields(lines[i])
+	if len(fields) != 2 || fields[0] != headerPrefix || !strings.HasPrefix(fields[1], "v") {
+		return 1, data, 0, nil
+	}
+	version, err = strconv.Atoi(fields[1][1:])
+	if err != nil || fields[1][1:] != strconv.Itoa(version) {
+		return 1, data, 0, nil
+	}
+	if version < 1 || version > formatVersion {
+		return 0, "", i + 1, fmt.Errorf("file format v%d is not supported by this version of aqc (newest is v%d); please upgrade aqc", version, formatVersion)
+	}
+	lines[i] = ""
+	return version, strings.Join(lines, "\n"), i + 1, nil
+}
+
+// block is the lines of a command block that carry its content: blank lines
+// and comments outside a fenced body are left out. lineNos holds the 1-based
+// file line number of each entry in lines.
+type block struct {
+	lines   []string
+	lineNos []int
+}
+
+// splitBlocks splits the file content into separate command blocks.
+// Blocks are separated by a line containing exactly "---". Lines inside a
+// fenced command body are kept verbatim, including blank lines, comments and
+// "---".
+func splitBlocks(data string) []block {
+	var blocks []block
+	for _, n := range parseNodes(data, 0, false) {
+		if n.kind == nodeBlock {
+			blocks = append(blocks, n.body)
 		}
 	}
-	if len(currentBlock) > 0 {
-		blocks = append(blocks, strings.Join(currentBlock, "\n"))
+	return blocks
+}
+
+// parseBlocks splits the file content into separate command blocks and
+// returns the text of each block.
+func parseBlocks(data string) []string {
+	var blocks []string
+	for _, b := range splitBlocks(data) {
+		blocks = append(blocks, strings.Join(b.lines, "\n"))
 	}
 	return blocks
 }
 
-// parseCommands converts each block into a Command struct.
-// Each block must have at least two lines: the first is the command,
-// the second starts with a hyphen and contains the name and description.
+// parseCommands converts each block into a Command struct, skipping blocks
+// that are malformed.
 func parseCommands(blocks []string) []Command {
 	var commands []Command
-	for _, block := range blocks {
-		lines := strings.Split(block, "\n")
-		if len(lines) < 2 {
-			continue
+	for _, text := range blocks {
+		lines := strings.Split(text, "\n")
+		lineNos := make([]int, len(lines))
+		for i := range lineNos {
+			lineNos[i] = i + 1
+		}
+		if c, _ := parseBlock(block{lines: lines, lineNos: lineNos}, 1); c != nil {
+			commands = append(commands, *c)
 		}
-		cmdText := strings.TrimSpace(lines[0])
-		secondLine := strings.TrimSpace(lines[1])
-		if !strings.HasPrefix(secondLine, "-") {
+	}
+	return commands
+}
+
+// parseBlock converts a block into a Command.
+// Each block must have at least two lines: the first is the command,
+// the second starts with a hyphen and contains the name and description.
+// A command spanning several lines is written between two ``` lines and
+// is followed by the hyphen line. From format version 2 on, the hyphen line
+// may be followed by "key: value" attribute lines. The returned Command is nil
+// when the block is dropped; the diagnostics explain why. Their File field is
+// left empty.
+func parseBlock(b block, version int) (*Command, []Diagnostic) {
+	cmdText, infoIdx, ok := splitBody(b.lines)
+	if !ok {
+		return nil, []Diagnostic{blockDiag(b, 0, "unclosed "+fence+" fence; block skipped")}
+	}
+	if infoIdx >= len(b.lines) {
+		return nil, []Diagnostic{blockDiag(b, 0, "missing \"- Name: Description\" line after the command; block skipped")}
+	}
+	secondLine := strings.TrimSpace(b.lines[infoIdx])
+	if !strings.HasPrefix(secondLine, "-") {
+		return nil, []Diagnostic{blockDiag(b, infoIdx, fmt.Sprintf("expected \"- Name: Description\", got %q; block skipped", secondLine))}
+	}
+	// Remove the hyphen and any leading spaces.
+	name, description := parseInfo(strings.TrimSpace(secondLine[1:]))
+
+	c := &Command{
+		Cmd:         cmdText,
+		Name:        name,
+		Description: description,
+		Line:        b.lineNos[0],
+		EndLine:     b.lineNos[len(b.lineNos)-1],
+	}
+	var diags []Diagnostic
+	for i := infoIdx + 1; i < len(b.lines); i++ {
+		if version < 2 {
+			diags = append(diags, blockDiag(b, i, "unexpected line after the name line is ignored (missing --- separator?)"))
 			continue
 		}
-		// Remove the hyphen and any leading spaces.
-		info := strings.TrimSpace(secondLine[1:])
-		// Split the info into a name and description by the first colon.
-		parts := strings.SplitN(info, ":", 2)
-		name := strings.TrimSpace(parts[0])
-		description := ""
-		if len(parts) > 1 {
-			description = strings.TrimSpace(parts[1])
+		if err := parseAttribute(c, strings.TrimSpace(b.lines[i])); err != nil {
+			diags = append(diags, blockDiag(b, i, err.Error()+"; line ignored"))
 		}
-		commands = append(commands, Command{
-			Cmd:         cmdText,
-			Name:        name,
-			Description: description,
-		})
 	}
-	return commands
+	return c, diags
+}
+
+// blockDiag builds a diagnostic pointing at the first non-blank character of
+// line i of the block.
+func blockDiag(b block, i int, reason string) Diagnostic {
+	line := b.lines[i]
+	column := len(line) - len(strings.TrimLeft(line, " \t")) + 1
+	return Diagnostic{Line: b.lineNos[i], Column: column, Reason: reason}
+}
+
+// splitBody separates the command body from the remaining lines of a block and
+// returns the index of the first line after the body. A fenced body is
+// returned verbatim; otherwise the body is the trimmed first line. ok is false
+// when a fence is never closed.
+func splitBody(lines []string) (body string, next int, ok bool) {
+	closing := fenceOf(lines[0])
+	if closing == "" {
+		return strings.TrimSpace(lines[0]), 1, true
+	}
+	for i := 1; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == closing {
+			return strings.Join(lines[1:i], "\n"), i + 1, true
+		}
+	}
+	return "", 0, false
+}
+
+// fenceOf returns the run of backticks that opens a fenced body on line, or ""
+// when the line does not open one. Text after the backticks is ignored.
+func fenceOf(line string) string {
+	trimmed := strings.TrimSpace(line)
+	n := len(trimmed) - len(strings.TrimLeft(trimmed, "`"))
+	if n < len(fence) {
+		return ""
+	}
+	return trimmed[:n]
+}
+
+// parseInfo splits the text after the hyphen of a name line into the name and
+// the description. Either may be written as a double-quoted Go string, which
+// lets a name contain a colon and keeps surrounding spaces and special
+// characters. Plain names end at the first colon.
+func parseInfo(info string) (name, description string) {
+	if strings.HasPrefix(info, `"`) {
+		if quoted, err := strconv.QuotedPrefix(info); err == nil {
+			rest := strings.TrimSpace(info[len(quoted):])
+			if rest == "" || rest[0] == ':' {
+				name, _ = strconv.Unquote(quoted)
+				if rest != "" {
+					description = unquoteField(strings.TrimSpace(rest[1:]))
+				}
+				return name, description
+			}
+		}
+	}
+	// Split the info into a name and description by the first colon.
+	parts := strings.SplitN(info, ":", 2)
+	name = strings.TrimSpace(parts[0])
+	if len(parts) > 1 {
+		description = unquoteField(strings.TrimSpace(parts[1]))
+	}
+	return name, description
+}
+
+// unquoteField returns the value of s if it is a single double-quoted string,
+// and s itself otherwise.
+func unquoteField(s string) string {
+	if strings.HasPrefix(s, `"`) {
+		if v, err := strconv.Unquote(s); err == nil {
+			return v
+		}
+	}
+	return s
+}
+
+// RunResult describes how a command finished.
+type RunResult struct {
+	// ExitCode is the command's exit status, or 128+n when it was killed by
+	// signal n, following the shell convention.
+	ExitCode int
+	// Signal is the signal that killed the command, or nil.
+	Signal os.Signal
+	// Duration is how long the command ran.
+	Duration time.Duration
+	// Err is set when the command could not be started at all.
+	Err error
+}
+
+// Success reports whether the command ran and exited with status 0.
+func (r RunResult) Success() bool {
+	return r.ExitCode == 0 && r.Err == nil
+}
+
+// String describes the outcome, e.g. "exit status 2" or "killed by interrupt".
+func (r RunResult) String() string {
+	switch {
+	case r.Err != nil:
+		return r.Err.Error()
+	case r.Signal != nil:
+		return "killed by " + r.Signal.String()
+	}
+	return fmt.Sprintf("exit status %d", r.ExitCode)
+}
+
+// RunCommand executes the command's text with the shell given by commandArgv,
+// in the directory given by workDir and with the environment given by
+// commandEnv. While the command runs, Ctrl+C is left to the command
+// (it receives it from the terminal) and termination signals sent to AQC are
+// passed on to it. The run is recorded in the run history.
+func RunCommand(c Command) RunResult {
+	result := runAttached(c)
+	recordRun(c, result)
+	return result
 }
 
-// RunCommand executes the provided shell command using sh -c.
-func RunCommand(command string) {
-	cmd := exec.Command("sh", "-c", command)
+// runAttached runs c with AQC's standard streams, as RunCommand describes.
+func runAttached(c Command) RunResult {
+	cmd, err := commandExec(c)
+	if err != nil {
+		return RunResult{ExitCode: 1, Err: err}
+	}
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	cmd.Stdin = os.Stdin
-	if err := cmd.Run(); err != nil {
-		fmt.Printf("%sError executing command: %v%s\n", ColorRed, err, ColorReset)
+
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		return RunResult{ExitCode: 127, Err: err}
+	}
+	stop := forwardSignals(func(os.Signal) []*exec.Cmd { return []*exec.Cmd{cmd} }, false)
+	err = cmd.Wait()
+	stop()
+
+	return runResult(cmd.ProcessState, err, time.Since(start))
+}
+
+// commandExec prepares the process that runs c, without its standard streams.
+func commandExec(c Command) (*exec.Cmd, error) {
+	env, err := commandEnv(c)
+	if err != nil {
+		return nil, err
+	}
+	argv, err := commandArgv(c)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Dir = workDir(c)
+	cmd.Env = env
+	return cmd, nil
+}
+
+// forwardSignals keeps AQC alive on Ctrl+C and passes termination signals on
+// to the commands that cmds returns for each signal, until the returned
+// function is called. Without groups, the commands receive Ctrl+C from the
+// terminal themselves. With groups, each command leads a process group of its
+// own that the terminal does not reach, so every signal, SIGINT included,
+// goes to the whole group.
+func forwardSignals(cmds func(sig os.Signal) []*exec.Cmd, groups bool) (stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				if groups {
+					for _, cmd := range cmds(sig) {
+						signalGroup(cmd, sig)
+					}
+					continue
+				}
+				if sig == os.Interrupt {
+					continue
+				}
+				for _, cmd := range cmds(sig) {
+					cmd.Process.Signal(sig)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
 	}
 }
 
+// runResult builds a RunResult from a finished process.
+func runResult(state *os.ProcessState, err error, duration time.Duration) RunResult {
+	result := RunResult{Duration: duration}
+	if state == nil {
+		result.ExitCode = 1
+		result.Err = err
+		return result
+	}
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		result.Signal = status.Signal()
+		result.ExitCode = 128 + int(status.Signal())
+		return result
+	}
+	result.ExitCode = state.ExitCode()
+	return result
+}
+
+// formatBlock renders a command as a block in the commands file format,
+// followed by a separator.
+func formatBlock(c Command) string {
+	return encodeBlock(c) + "\n---\n"
+}
+
+// encodeBlock renders the lines of a command's block without a separator or
+// trailing newline. The command is fenced and the name and description are
+// quoted whenever that is needed for them to read back unchanged. Attributes
+// follow the name line.
+func encodeBlock(c Command) string {
+	info := "- " + encodeField(c.Name, true)
+	if c.Description != "" {
+		info += ": " + encodeField(c.Description, false)
+	}
+	lines := append([]string{encodeCmd(c.Cmd), strings.TrimRight(info, " ")}, attributeLines(c)...)
+	return strings.Join(lines, "\n")
+}
+
+// encodeCmd renders a command body. Commands that would not survive as a
+// single trimmed line, or that would be taken for a separator, a fence, a
+// comment, a @defaults block or a group header, are wrapped in a fence longer
+// than any backtick line inside them.
+func encodeCmd(cmd string) string {
+	if cmd != "" && !strings.Contains(cmd, "\n") && strings.TrimSpace(cmd) == cmd &&
+		cmd != "---" && cmd != defaultsMarker && groupName(cmd) == "" && fenceOf(cmd) == "" && !strings.HasPrefix(cmd, "#") {
+		return cmd
+	}
+	f := fence
+	for _, line := range strings.Split(cmd, "\n") {
+		if trimmed := strings.TrimSpace(line); fenceOf(trimmed) == trimmed && len(trimmed) >= len(f) {
+			f = trimmed + "`"
+		}
+	}
+	return f + "\n" + cmd + "\n" + f
+}
+
+// encodeField renders a name or description for the name line, quoting it
+// when it would not read back unchanged as plain text.
+func encodeField(s string, isName bool) string {
+	needsQuotes := strings.TrimSpace(s) != s || strings.HasPrefix(s, `"`) ||
+		!utf8.ValidString(s) || strings.IndexFunc(s, func(r rune) bool { return !strconv.IsPrint(r) }) >= 0
+	if isName && strings.Contains(s, ":") {
+		needsQuotes = true
+	}
+	if needsQuotes {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
 // AppendCommand appends a new command block to the commands file.
 func AppendCommand(c Command) error {
-	block := fmt.Sprintf("%s\n- %s: %s\n---\n", c.Cmd, c.Name, c.Description)
-	f, err := os.OpenFile(commandsFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	return AppendCommandTo(commandsFile, c)
+}
+
+// AppendCommandTo appends a new command to the commands file at path,
+// creating the file and its directory if needed. A new file starts with the
+// header of the current format version.
+func AppendCommandTo(path string, c Command) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if formatForPath(path).name != "aqc" {
+		commands, err := readEntries(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return writeEntries(path, append(commands, c))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	block := formatBlock(c)
+	flags := os.O_APPEND | os.O_CREATE | os.O_WRONLY
+	if strings.TrimSpace(string(data)) == "" {
+		data = []byte(formatHeader(formatVersion) + "\n")
+		block = formatHeader(formatVersion) + "\n" + block
+		flags |= os.O_TRUNC
+	} else if version := requiredVersion(c); version > 1 {
+		current, _, _, err := splitHeader(string(data))
+		switch {
+		case err != nil:
+			return err
+		case current < version:
+			return fmt.Errorf("%s uses file format v%d; run 'aqc migrate' to use attributes such as dir", path, current)
+		}
+	}
+	// Group headers decide where a command goes, so the file is rewritten.
+	if doc, _ := ParseDocument(path, string(data)); c.Group != "" || doc.hasGroups() {
+		if _, _, _, err := splitHeader(string(data)); err != nil {
+			return err
+		}
+		if err := doc.Append(c); err != nil {
+			return err
+		}
+		return doc.Save()
+	}
+	f, err := os.OpenFile(path, flags, 0644)
 	if err != nil {
 		return err
 	}
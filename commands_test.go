@@ -1,9 +1,15 @@
 package main
 
 import (
+	"math/rand"
 	"os"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"syscall"
 	"testing"
+	"testing/quick"
 )
 
 func TestParseBlocks(t *testing.T) {
@@ -42,6 +48,11 @@ func TestParseBlocks(t *testing.T) {
 			input:    "cmd1\n- Name1: Desc1\n---\n---\ncmd2\n- Name2: Desc2",
 			expected: []string{"cmd1\n- Name1: Desc1", "cmd2\n- Name2: Desc2"},
 		},
+		{
+			name:     "fenced body keeps blank lines and separators",
+			input:    "```\necho one\n\n---\necho two\n```\n- Multi: Two steps\n---\npwd\n- Dir",
+			expected: []string{"```\necho one\n\n---\necho two\n```\n- Multi: Two steps", "pwd\n- Dir"},
+		},
 	}
 
 	for _, tt := range tests {
@@ -110,6 +121,23 @@ func TestParseCommands(t *testing.T) {
 				{Cmd: "echo 'hello:world'", Name: "Echo Test", Description: "Prints hello:world to stdout"},
 			},
 		},
+		{
+			name:   "fenced multi-line command",
+			blocks: []string{"```\nset -e\n  make build\nmake deploy\n```\n- Deploy: Build and deploy"},
+			expected: []Command{
+				{Cmd: "set -e\n  make build\nmake deploy", Name: "Deploy", Description: "Build and deploy"},
+			},
+		},
+		{
+			name:     "invalid block - unclosed fence",
+			blocks:   []string{"```\necho one\n- Name: Desc"},
+			expected: nil,
+		},
+		{
+			name:     "invalid block - fence without name line",
+			blocks:   []string{"```\necho one\n```"},
+			expected: nil,
+		},
 		{
 			name:   "command with extra whitespace",
 			blocks: []string{"  ls -la  \n  - List Files  :  List all files  "},
@@ -219,6 +247,224 @@ func TestAppendCommand(t *testing.T) {
 	}
 }
 
+func TestParseCommandFileDiagnostics(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        string
+		wantCommands int
+		expected     []Diagnostic
+	}{
+		{
+			name:         "clean file",
+			input:        "ls\n- List: List files\n---\npwd\n- Dir\n",
+			wantCommands: 2,
+			expected:     nil,
+		},
+		{
+			name:         "missing name line",
+			input:        "ls\n- List: List files\n---\n\npwd\n---\n",
+			wantCommands: 1,
+			expected:     []Diagnostic{{File: "f", Line: 5, Column: 1}},
+		},
+		{
+			name:         "second line without hyphen",
+			input:        "ls\n  List: List files\n",
+			wantCommands: 0,
+			expected:     []Diagnostic{{File: "f", Line: 2, Column: 3}},
+		},
+		{
+			name:         "unclosed fence",
+			input:        "pwd\n- Dir\n---\n```\necho hi\n- Name: Desc\n",
+			wantCommands: 1,
+			expected:     []Diagnostic{{File: "f", Line: 4, Column: 1}},
+		},
+		{
+			name:         "missing separator between commands",
+			input:        "ls\n- List\npwd\n- Dir\n",
+			wantCommands: 1,
+			expected:     []Diagnostic{{File: "f", Line: 3, Column: 1}, {File: "f", Line: 4, Column: 1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commands, diags := ParseCommandFile("f", tt.input)
+			if len(commands) != tt.wantCommands {
+				t.Errorf("ParseCommandFile() returned %d commands, expected %d", len(commands), tt.wantCommands)
+			}
+			if len(diags) != len(tt.expected) {
+				t.Fatalf("ParseCommandFile() returned %d diagnostics, expected %d: %v", len(diags), len(tt.expected), diags)
+			}
+			for i, d := range diags {
+				if d.File != tt.expected[i].File || d.Line != tt.expected[i].Line || d.Column != tt.expected[i].Column {
+					t.Errorf("diagnostic[%d] = %s, expected %s:%d:%d", i, d, tt.expected[i].File, tt.expected[i].Line, tt.expected[i].Column)
+				}
+				if d.Reason == "" {
+					t.Errorf("diagnostic[%d] has no reason", i)
+				}
+			}
+		})
+	}
+}
+
+func TestFormatBlockRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		command Command
+	}{
+		{"single line", Command{Cmd: "ls -la", Name: "List", Description: "List files"}},
+		{"multi-line", Command{Cmd: "set -e\nmake build\nmake deploy", Name: "Deploy", Description: "Ship it"}},
+		{"multi-line with separator and blank line", Command{Cmd: "cat <<EOF\n---\n\nkey: value\nEOF", Name: "Heredoc", Description: ""}},
+		{"colon in name", Command{Cmd: "make deploy ENV=staging", Name: "Deploy: staging", Description: "Ship to staging"}},
+		{"separator as command", Command{Cmd: "---", Name: "Dashes", Description: "- leading hyphen"}},
+		{"fence inside command", Command{Cmd: "cat <<EOF\n```\ncode\n````\nEOF", Name: "Markdown", Description: "Print a code block"}},
+		{"command starting with a fence", Command{Cmd: "```", Name: "Backticks", Description: ""}},
+		{"surrounding spaces", Command{Cmd: "  echo hi  ", Name: " padded ", Description: "  "}},
+		{"quotes", Command{Cmd: `echo "hi"`, Name: `"quoted"`, Description: `"a" and "b"`}},
+		{"newlines in fields", Command{Cmd: "\n", Name: "two\nlines", Description: "tab\there\r\n"}},
+		{"empty", Command{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commands := parseCommands(parseBlocks(formatBlock(tt.command)))
+			if len(commands) != 1 {
+				t.Fatalf("Expected 1 command, got %d", len(commands))
+			}
+			c := commands[0]
+			if c.Cmd != tt.command.Cmd || c.Name != tt.command.Name || c.Description != tt.command.Description {
+				t.Errorf("Round trip = %+v, expected %+v", c, tt.command)
+			}
+		})
+	}
+}
+
+func TestParseCommandFileVersions(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		commands []string
+		diags    []int
+	}{
+		{"no header is v1", "ls\n- List\nextra\n---\n", []string{"List"}, []int{3}},
+		{"explicit v1", "#aqc v1\nls\n- List\n---\n", []string{"List"}, nil},
+		{"v2 header", "\n#aqc v2\n\nls\n- List: files\n---\n", []string{"List"}, nil},
+		{"v2 unknown attribute", "#aqc v2\nls\n- List\ncolour: red\nnot an attribute\n---\n", []string{"List"}, []int{4, 5}},
+		{"newer version", "#aqc v99\nls\n- List\n---\n", nil, []int{1}},
+		{"other #aqc line is a comment", "\n#aqc commands for this repo\nls\n- List\n---\n", []string{"List"}, nil},
+		{"#aqc v line with more words is a comment", "#aqc v2 for the web team\nls\n- List\n---\n", []string{"List"}, nil},
+		{"other hash line is a comment", "#aqcfoo\nls\n- List\n---\n", []string{"List"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commands, diags := ParseCommandFile("f", tt.input)
+			var names []string
+			for _, c := range commands {
+				names = append(names, c.Name)
+			}
+			if !reflect.DeepEqual(names, tt.commands) {
+				t.Errorf("commands = %v, expected %v", names, tt.commands)
+			}
+			var lines []int
+			for _, d := range diags {
+				lines = append(lines, d.Line)
+			}
+			if !reflect.DeepEqual(lines, tt.diags) {
+				t.Errorf("diagnostic lines = %v, expected %v (%v)", lines, tt.diags, diags)
+			}
+		})
+	}
+}
+
+func TestParseInfo(t *testing.T) {
+	tests := []struct {
+		info        string
+		name        string
+		description string
+	}{
+		{"Build: Build the app", "Build", "Build the app"},
+		{"Build", "Build", ""},
+		{"Deploy: staging: now", "Deploy", "staging: now"},
+		{`"Deploy: staging": Ship it`, "Deploy: staging", "Ship it"},
+		{`"Deploy: staging"`, "Deploy: staging", ""},
+		{`Name: "  padded  "`, "Name", "  padded  "},
+		{`Name: "a" and "b"`, "Name", `"a" and "b"`},
+		{`"Half" quoted: desc`, `"Half" quoted`, "desc"},
+		{`"unterminated: desc`, `"unterminated`, "desc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.info, func(t *testing.T) {
+			name, description := parseInfo(tt.info)
+			if name != tt.name || description != tt.description {
+				t.Errorf("parseInfo(%q) = %q, %q, expected %q, %q", tt.info, name, description, tt.name, tt.description)
+			}
+		})
+	}
+}
+
+// trickyCommand is a Command whose fields are built from fragments that have
+// a meaning in the commands file format, so random values hit the escaping
+// rules far more often than uniformly random strings would.
+type trickyCommand Command
+
+func (trickyCommand) Generate(r *rand.Rand, size int) reflect.Value {
+	fragments := []string{"a", "Z", " ", "\t", "\n", "\r", ":", "-", "---", "```", "````", "`", `"`, "\\", "#", "{{x}}", "é", "\x00", "\xff"}
+	field := func() string {
+		var b strings.Builder
+		for n := r.Intn(size + 1); n > 0; n-- {
+			b.WriteString(fragments[r.Intn(len(fragments))])
+		}
+		return b.String()
+	}
+	return reflect.ValueOf(trickyCommand{Cmd: field(), Name: field(), Description: field()})
+}
+
+func TestEncodeBlockRoundTripProperty(t *testing.T) {
+	roundTrips := func(want Command) bool {
+		commands, diags := ParseCommandFile("test", formatBlock(want))
+		if len(commands) != 1 || len(diags) != 0 {
+			t.Logf("%q: got %d commands, diagnostics %v", formatBlock(want), len(commands), diags)
+			return false
+		}
+		got := commands[0]
+		if got.Cmd != want.Cmd || got.Name != want.Name || got.Description != want.Description {
+			t.Logf("%q: got %+v", formatBlock(want), got)
+			return false
+		}
+		return true
+	}
+
+	t.Run("arbitrary strings", func(t *testing.T) {
+		f := func(cmd, name, description string) bool {
+			return roundTrips(Command{Cmd: cmd, Name: name, Description: description})
+		}
+		if err := quick.Check(f, &quick.Config{MaxCount: 2000}); err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("format fragments", func(t *testing.T) {
+		f := func(c trickyCommand) bool {
+			return roundTrips(Command(c))
+		}
+		if err := quick.Check(f, &quick.Config{MaxCount: 5000}); err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("several blocks", func(t *testing.T) {
+		f := func(a, b trickyCommand) bool {
+			commands, _ := ParseCommandFile("test", formatBlock(Command(a))+formatBlock(Command(b)))
+			return len(commands) == 2 &&
+				commands[0].Cmd == a.Cmd && commands[0].Name == a.Name && commands[0].Description == a.Description &&
+				commands[1].Cmd == b.Cmd && commands[1].Name == b.Name && commands[1].Description == b.Description
+		}
+		if err := quick.Check(f, &quick.Config{MaxCount: 2000}); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
 func TestParseBlocksAndCommands_Integration(t *testing.T) {
 	// Test the full flow from raw file content to parsed commands
 	fileContent := `ls -la
@@ -311,6 +557,76 @@ date
 	}
 }
 
+func TestRunCommandResult(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+
+	tests := []struct {
+		name         string
+		command      Command
+		exitCode     int
+		signal       os.Signal
+		expectErr    bool
+		expectString string
+	}{
+		{"success", Command{Cmd: "true"}, 0, nil, false, "exit status 0"},
+		{"exit status", Command{Cmd: "exit 3"}, 3, nil, false, "exit status 3"},
+		{"killed by signal", Command{Cmd: "kill -TERM $$"}, 128 + int(syscall.SIGTERM), syscall.SIGTERM, false, "killed by terminated"},
+		{"cannot start", Command{Cmd: "true", Source: filepath.Join(t.TempDir(), "missing", commandsFile)}, 127, nil, true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := RunCommand(tt.command)
+			if result.ExitCode != tt.exitCode {
+				t.Errorf("ExitCode = %d, expected %d", result.ExitCode, tt.exitCode)
+			}
+			if result.Signal != tt.signal {
+				t.Errorf("Signal = %v, expected %v", result.Signal, tt.signal)
+			}
+			if (result.Err != nil) != tt.expectErr {
+				t.Errorf("Err = %v, expectErr %v", result.Err, tt.expectErr)
+			}
+			if result.Success() != (tt.exitCode == 0 && !tt.expectErr) {
+				t.Errorf("Success() = %v", result.Success())
+			}
+			if tt.expectString != "" && result.String() != tt.expectString {
+				t.Errorf("String() = %q, expected %q", result.String(), tt.expectString)
+			}
+		})
+	}
+}
+
+func TestRunCommandRunsInSourceDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	result := RunCommand(Command{Cmd: "pwd > out", Source: filepath.Join(dir, commandsFile)})
+	if !result.Success() {
+		t.Fatalf("RunCommand() = %s", result)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("Command did not run in the source directory: %v", err)
+	}
+	if got, _ := filepath.EvalSymlinks(string(data[:len(data)-1])); got != mustEvalSymlinks(t, dir) {
+		t.Errorf("Command ran in %q, expected %q", got, dir)
+	}
+}
+
+func mustEvalSymlinks(t *testing.T, path string) string {
+	t.Helper()
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		t.Fatalf("EvalSymlinks(%q) error = %v", path, err)
+	}
+	return resolved
+}
+
 // Helper function
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && (s == substr || len(s) > 0 && containsHelper(s, substr))
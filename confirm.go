@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// assumeYes runs commands that need confirmation without asking. --yes sets
+// it for scripts.
+var assumeYes bool
+
+// dangerWords are words that make a command look destructive on their own.
+// Flags match with a value too, as in --force=true.
+var dangerWords = []string{"--force", "destroy", "drop"}
+
+// confirmReason returns why c must be confirmed before it runs, or "" when it
+// can run straight away. A confirm attribute decides; without one, commands
+// that look destructive are confirmed.
+func confirmReason(c Command) string {
+	if c.Confirm != nil {
+		if *c.Confirm {
+			return "it is marked confirm: true"
+		}
+		return ""
+	}
+	if danger := dangerReason(c.Cmd); danger != "" {
+		return "it looks destructive: " + danger
+	}
+	return ""
+}
+
+// dangerReason returns the part of cmd that looks destructive, such as
+// "rm -rf" or "drop", or "" when there is none. Case and quotes are ignored,
+// so SQL such as psql -c "DROP DATABASE app" is caught too.
+func dangerReason(cmd string) string {
+	words := strings.Fields(strings.ToLower(cmd))
+	for i := range words {
+		words[i] = strings.Trim(words[i], `"'();`)
+	}
+	for i, word := range words {
+		if word == "rm" || strings.HasSuffix(word, "/rm") {
+			if removesRecursively(words[i+1:]) {
+				return "rm -rf"
+			}
+			continue
+		}
+		for _, danger := range dangerWords {
+			if word == danger || strings.HasPrefix(danger, "--") && strings.HasPrefix(word, danger+"=") {
+				return danger
+			}
+		}
+	}
+	return ""
+}
+
+// removesRecursively reports whether the arguments of an rm command ask for
+// both -r and -f, separately or together.
+func removesRecursively(args []string) bool {
+	recursive, force := false, false
+	for _, arg := range args {
+		switch {
+		case arg == "--recursive":
+			recursive = true
+		case arg == "--force":
+			force = true
+		case strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--"):
+			recursive = recursive || strings.Contains(arg, "r")
+			force = force || strings.Contains(arg, "f")
+		case arg == "&&" || arg == "||" || arg == "|" || arg == "":
+			// The next command starts; its flags are not rm's.
+			return false
+		}
+		if recursive && force {
+			return true
+		}
+	}
+	return false
+}
+
+// confirmed reports whether answer confirms running c: "y", "yes" or the
+// command's name.
+func confirmed(answer string, c Command) bool {
+	answer = strings.TrimSpace(answer)
+	return strings.EqualFold(answer, "y") || strings.EqualFold(answer, "yes") || answer == c.Name
+}
+
+// confirmSteps asks the user to confirm each step that needs it, in the
+// terminal UI, which it starts if restore is nil. ok is false when the user
+// declined. With --yes nothing is asked; without a terminal to ask on, it
+// fails instead of running the step.
+func confirmSteps(steps []Command, restore *func()) (ok bool, err error) {
+	if assumeYes {
+		return true, nil
+	}
+	for _, c := range steps {
+		reason := confirmReason(c)
+		if reason == "" {
+			continue
+		}
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return false, fmt.Errorf("%q needs confirmation because %s; pass --yes to run it without asking", c.Name, reason)
+		}
+		if *restore == nil {
+			*restore = startTUI()
+		}
+		if !confirmDialog(c, reason) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// confirmDialog shows c in red with the reason it needs confirmation and
+// reads the answer.
+func confirmDialog(c Command, reason string) bool {
+	ClearScreen()
+	PrintHeader()
+	printLine(ColorRed + "⚠  Run " + c.Name + "? Confirm first, because " + reason + "." + ColorReset)
+	printLine("")
+	for _, line := range strings.Split(c.Cmd, "\n") {
+		printLine(ColorRed + "  $ " + line + ColorReset)
+	}
+	printLine("")
+	printLine(ColorYellow + "Type y or the command name and press Enter to run it | Cancel: Esc" + ColorReset)
+	answer, ok := readLine(ColorRed+"Confirm: "+ColorReset, "", nil)
+	return ok && confirmed(answer, c)
+}
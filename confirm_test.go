@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDangerReason(t *testing.T) {
+	tests := []struct {
+		cmd      string
+		expected string
+	}{
+		{"rm -rf build", "rm -rf"},
+		{"rm -fr build", "rm -rf"},
+		{"sudo /bin/rm -r -f /tmp/x", "rm -rf"},
+		{"rm --recursive --force dist", "rm -rf"},
+		{"rm -r build && make -f Makefile", ""},
+		{"rm build.log", ""},
+		{"git push --force origin main", "--force"},
+		{"git push --force-with-lease", ""},
+		{"helm upgrade --force=true app", "--force"},
+		{"cd infra\nterraform destroy", "destroy"},
+		{`psql -c "DROP DATABASE app;"`, "drop"},
+		{"make dropdown", ""},
+		{"ls -la", ""},
+	}
+	for _, tt := range tests {
+		if got := dangerReason(tt.cmd); got != tt.expected {
+			t.Errorf("dangerReason(%q) = %q, expected %q", tt.cmd, got, tt.expected)
+		}
+	}
+}
+
+func TestConfirmReason(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		name     string
+		command  Command
+		expected string
+	}{
+		{"safe", Command{Cmd: "ls"}, ""},
+		{"guessed", Command{Cmd: "terraform destroy"}, "destroy"},
+		{"confirm: true", Command{Cmd: "ls", Confirm: &yes}, "confirm: true"},
+		{"confirm: false wins over the guess", Command{Cmd: "rm -rf build", Confirm: &no}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := confirmReason(tt.command)
+			if tt.expected == "" && got != "" || !strings.Contains(got, tt.expected) {
+				t.Errorf("confirmReason() = %q, expected it to mention %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestConfirmed(t *testing.T) {
+	c := Command{Name: "Drop DB", Cmd: "dropdb app"}
+	for _, answer := range []string{"y", "Y", "yes", " Drop DB "} {
+		if !confirmed(answer, c) {
+			t.Errorf("confirmed(%q) = false", answer)
+		}
+	}
+	for _, answer := range []string{"", "n", "drop db", "sure"} {
+		if confirmed(answer, c) {
+			t.Errorf("confirmed(%q) = true", answer)
+		}
+	}
+}
+
+func TestConfirmStepsWithoutTerminal(t *testing.T) {
+	steps := []Command{{Name: "Build", Cmd: "make"}, {Name: "Clean", Cmd: "rm -rf build"}}
+	var restore func()
+
+	// Tests do not run on a terminal, so there is nobody to ask.
+	ok, err := confirmSteps(steps, &restore)
+	if ok || err == nil || !strings.Contains(err.Error(), "--yes") || restore != nil {
+		t.Errorf("confirmSteps() = %v, %v; expected an error that mentions --yes", ok, err)
+	}
+	if ok, err := confirmSteps(steps[:1], &restore); !ok || err != nil {
+		t.Errorf("confirmSteps() on safe steps = %v, %v", ok, err)
+	}
+
+	assumeYes = true
+	defer func() { assumeYes = false }()
+	if ok, err := confirmSteps(steps, &restore); !ok || err != nil {
+		t.Errorf("confirmSteps() with --yes = %v, %v", ok, err)
+	}
+}
+
+func TestConfirmAttribute(t *testing.T) {
+	content := "#aqc v2\nterraform apply\n- Apply\nconfirm: true\n---\n"
+	commands, diags := ParseCommandFile("f", content)
+	if len(diags) != 0 || len(commands) != 1 || commands[0].Confirm == nil || !*commands[0].Confirm {
+		t.Fatalf("ParseCommandFile() = %+v, %v", commands, diags)
+	}
+	if got := formatHeader(2) + "\n" + formatBlock(commands[0]); got != content {
+		t.Errorf("formatBlock() = %q, expected %q", got, content)
+	}
+	if _, diags := ParseCommandFile("f", "#aqc v2\nls\n- List\nconfirm: maybe\n"); len(diags) != 1 {
+		t.Errorf("confirm: maybe gave diagnostics %v, expected one", diags)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ConvertSubcommand handles the "convert" subcommand to translate a command
+// file into another format.
+func ConvertSubcommand() {
+	convertCmd := flag.NewFlagSet("convert", flag.ExitOnError)
+	toPtr := convertCmd.String("to", "", "The format to convert to: aqc, yaml, toml or json")
+	outPtr := convertCmd.String("out", "", "Where to write the result, or - for stdout (default: next to the input)")
+	forcePtr := convertCmd.Bool("force", false, "Overwrite the output file if it exists")
+	convertCmd.Usage = func() {
+		fmt.Fprintln(convertCmd.Output(), "Usage: aqc convert --to=<format> [--out=<path|->] [--force] [file]")
+		convertCmd.PrintDefaults()
+	}
+	// The file may come before or after the flags.
+	convertCmd.Parse(os.Args[2:])
+	path := convertCmd.Arg(0)
+	if convertCmd.NArg() > 0 {
+		convertCmd.Parse(convertCmd.Args()[1:])
+	}
+
+	to, err := formatNamed(*toPtr)
+	if err != nil {
+		fmt.Printf("%sError: %v%s\n", ColorRed, err, ColorReset)
+		os.Exit(2)
+	}
+	if path == "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			fmt.Printf("%sError getting current directory: %v%s\n", ColorRed, err, ColorReset)
+			os.Exit(1)
+		}
+		files := projectCommandFiles(cwd)
+		if len(files) == 0 {
+			fmt.Println(ColorRed + "Error: no " + commandsFile + " file found." + ColorReset)
+			os.Exit(1)
+		}
+		path = files[0]
+	}
+
+	data, count, err := ConvertFile(path, to)
+	if err != nil {
+		fmt.Printf("%sError converting %s: %v%s\n", ColorRed, path, err, ColorReset)
+		os.Exit(1)
+	}
+
+	out := *outPtr
+	if out == "-" {
+		os.Stdout.Write(data)
+		return
+	}
+	if out == "" {
+		out = convertedPath(path, to)
+	}
+	if _, err := os.Stat(out); err == nil && !*forcePtr {
+		fmt.Printf("%sError: %s already exists; use --force to overwrite it.%s\n", ColorRed, out, ColorReset)
+		os.Exit(1)
+	}
+	if err := writeFileAtomic(out, data); err != nil {
+		fmt.Printf("%sError writing %s: %v%s\n", ColorRed, out, err, ColorReset)
+		os.Exit(1)
+	}
+	fmt.Printf("%sConverted %d commands from %s to %s.%s\n", ColorGreen, count, relPath(path), relPath(out), ColorReset)
+	fmt.Println("Both files are loaded while they exist side by side; remove the one you no longer need.")
+}
+
+// convertedPath returns where the file at path goes when it is converted to
+// the format to: next to it, with the extension of that format. A file using
+// the short .aqc base name becomes .commands.aqc, so that it is found again.
+func convertedPath(path string, to fileFormat) string {
+	base := strings.TrimSuffix(path, formatForPath(path).extOf(path))
+	if filepath.Base(base) == shortCommandsFile {
+		base = filepath.Join(filepath.Dir(base), commandsFile)
+	}
+	return base + to.ext
+}
+
+// ConvertFile renders the commands of the file at path in the format to and
+// returns the result with the number of commands. It fails rather than lose
+// anything: every command must read back unchanged from the result.
+func ConvertFile(path string, to fileFormat) ([]byte, int, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	commands, diags := formatForPath(path).loader.Decode(path, raw)
+	if len(diags) > 0 {
+		return nil, 0, fmt.Errorf("the file has %d problem(s); fix the ones 'aqc lint' reports first", len(diags))
+	}
+	data, err := to.loader.Encode(commands)
+	if err != nil {
+		return nil, 0, err
+	}
+	converted, diags := to.loader.Decode(path, data)
+	if len(diags) > 0 || !sameCommands(commands, converted) {
+		return nil, 0, fmt.Errorf("some commands cannot be written as %s without changing them", to.name)
+	}
+	return data, len(commands), nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fileOverride is the project commands file given with --file. It takes
+// precedence over the AQC_FILE environment variable.
+var fileOverride string
+
+// projectFileOverride returns the project commands file chosen with --file or
+// AQC_FILE, or an empty string when the file should be discovered.
+func projectFileOverride() string {
+	if fileOverride != "" {
+		return fileOverride
+	}
+	return os.Getenv("AQC_FILE")
+}
+
+// parseGlobalFlags removes the --file flag given before the subcommand from
+// args, records its value, and returns the remaining arguments.
+func parseGlobalFlags(args []string) []string {
+	for len(args) > 1 {
+		switch {
+		case strings.HasPrefix(args[1], "--file="):
+			fileOverride = strings.TrimPrefix(args[1], "--file=")
+			args = append(args[:1:1], args[2:]...)
+		case args[1] == "--file" && len(args) > 2:
+			fileOverride = args[2]
+			args = append(args[:1:1], args[3:]...)
+		default:
+			return args
+		}
+	}
+	return args
+}
+
+// globalCommandsFile returns the path of the user-level commands file,
+// $XDG_CONFIG_HOME/aqc/commands.aqc, falling back to ~/.config when
+// XDG_CONFIG_HOME is not set.
+func globalCommandsFile() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "aqc", "commands.aqc")
+}
+
+// dataDir returns the directory AQC keeps its state in,
+// $XDG_DATA_HOME/aqc, falling back to ~/.local/share when XDG_DATA_HOME is
+// not set.
+func dataDir() string {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ".aqc"
+		}
+		dir = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dir, "aqc")
+}
+
+// projectCommandFiles returns the project commands files to load, nearest
+// first: the override file if one is set, otherwise those found from dir.
+func projectCommandFiles(dir string) []string {
+	if override := projectFileOverride(); override != "" {
+		return []string{override}
+	}
+	return findCommandFiles(dir)
+}
+
+// findCommandFiles returns the commands files found in dir and its parents,
+// nearest first, in any of the supported formats. The search stops at the
+// repository root (the first directory containing .git) or at the filesystem
+// root.
+func findCommandFiles(dir string) []string {
+	var files []string
+	for {
+		for _, f := range fileFormats {
+			for _, name := range f.fileNames() {
+				path := filepath.Join(dir, name)
+				if info, err := os.Stat(path); err == nil && !info.IsDir() {
+					files = append(files, path)
+				}
+			}
+		}
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return files
+}
+
+// localCommandsFile returns the commands file in dir that new commands go
+// to: the first one that exists in any of the supported formats, or
+// .commands.aqc when there is none yet.
+func localCommandsFile(dir string) string {
+	for _, f := range fileFormats {
+		for _, name := range f.fileNames() {
+			path := filepath.Join(dir, name)
+			if info, err := os.Stat(path); err == nil && !info.IsDir() {
+				return path
+			}
+		}
+	}
+	return filepath.Join(dir, commandsFile)
+}
+
+// loadCommandFiles parses each file and merges the results. Paths are given
+// nearest first and their commands are listed in that order; when two files
+// define a command with the same name, the one from the nearer file wins.
+// Commands read from the global file, if it is non-empty and exists, come last
+// and are marked as global. A name used twice in one file is reported, and
+// both commands are kept.
+func loadCommandFiles(paths []string, global string) ([]Command, []Diagnostic, error) {
+	var commands []Command
+	var diags []Diagnostic
+	seen := make(map[string]bool)
+	if global != "" {
+		if _, err := os.Stat(global); err == nil {
+			paths = append(paths[:len(paths):len(paths)], global)
+		}
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		fileCommands, fileDiags := formatForPath(path).loader.Decode(relPath(path), data)
+		diags = append(append(diags, fileDiags...), duplicateNames(fileCommands)...)
+		for _, c := range fileCommands {
+			if seen[c.Name] {
+				continue
+			}
+			c.Global = path == global
+			commands = append(commands, c)
+		}
+		// Only the files further up are shadowed by this one.
+		for _, c := range fileCommands {
+			seen[c.Name] = true
+		}
+	}
+	return commands, diags, nil
+}
+
+// duplicateNames reports the commands of one file that reuse the name of an
+// earlier command in it.
+func duplicateNames(commands []Command) []Diagnostic {
+	var diags []Diagnostic
+	first := make(map[string]Command)
+	for _, c := range commands {
+		prev, ok := first[c.Name]
+		if !ok {
+			first[c.Name] = c
+			continue
+		}
+		reason := fmt.Sprintf("command %q is defined more than once", c.Name)
+		if prev.Line > 0 {
+			reason = fmt.Sprintf("command %q is already defined on line %d", c.Name, prev.Line)
+		}
+		diags = append(diags, Diagnostic{File: c.Source, Line: c.Line, Column: 1, Reason: reason})
+	}
+	return diags
+}
+
+// relPath returns path relative to the working directory when possible, so
+// file names stay short in menus and messages.
+func relPath(path string) string {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+	rel, err := filepath.Rel(cwd, path)
+	if err != nil {
+		return path
+	}
+	return rel
+}
+
+// multipleSources reports whether the project commands come from more than
+// one file.
+func multipleSources(commands []Command) bool {
+	first := ""
+	for _, c := range commands {
+		if c.Global {
+			continue
+		}
+		if first == "" {
+			first = c.Source
+		} else if c.Source != first {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFindCommandFiles(t *testing.T) {
+	root := t.TempDir()
+	repo := filepath.Join(root, "repo")
+	nested := filepath.Join(repo, "src", "foo")
+	if err := os.MkdirAll(nested, 0755); err != nil {
+		t.Fatalf("Failed to create directories: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(repo, ".git"), 0755); err != nil {
+		t.Fatalf("Failed to create .git: %v", err)
+	}
+	for _, dir := range []string{root, repo, nested} {
+		if err := os.WriteFile(filepath.Join(dir, commandsFile), []byte("ls\n- List\n"), 0644); err != nil {
+			t.Fatalf("Failed to write commands file: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		dir      string
+		expected []string
+	}{
+		{"nested dir stops at git root", nested, []string{filepath.Join(nested, commandsFile), filepath.Join(repo, commandsFile)}},
+		{"dir without its own file", filepath.Join(repo, "src"), []string{filepath.Join(repo, commandsFile)}},
+		{"git root itself", repo, []string{filepath.Join(repo, commandsFile)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := findCommandFiles(tt.dir)
+			if !reflect.DeepEqual(files, tt.expected) {
+				t.Errorf("findCommandFiles() = %v, expected %v", files, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFindCommandFilesFormats(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, ".git"), 0755); err != nil {
+		t.Fatalf("Failed to create .git: %v", err)
+	}
+	names := []string{commandsFile, ".commands.aqc.yml", ".aqc.toml", ".aqc.json"}
+	var expected []string
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatalf("Failed to write commands file: %v", err)
+		}
+		expected = append(expected, path)
+	}
+	if files := findCommandFiles(dir); !reflect.DeepEqual(files, expected) {
+		t.Errorf("findCommandFiles() = %v, expected %v", files, expected)
+	}
+}
+
+func TestLoadCommandFilesNearestWins(t *testing.T) {
+	dir := t.TempDir()
+	near := filepath.Join(dir, "near.aqc")
+	far := filepath.Join(dir, "far.aqc")
+	if err := os.WriteFile(near, []byte("make build\n- Build: Near build\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.WriteFile(far, []byte("make all\n- Build: Far build\n---\nmake test\n- Test: Far test\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	commands, diags, err := loadCommandFiles([]string{near, far}, filepath.Join(dir, "missing.aqc"))
+	if err != nil {
+		t.Fatalf("loadCommandFiles() error = %v", err)
+	}
+	if len(diags) != 0 {
+		t.Errorf("Expected no diagnostics, got %v", diags)
+	}
+	if len(commands) != 2 {
+		t.Fatalf("Expected 2 commands, got %d", len(commands))
+	}
+	if commands[0].Cmd != "make build" || filepath.Base(commands[0].Source) != "near.aqc" {
+		t.Errorf("Build should come from the nearer file, got %+v", commands[0])
+	}
+	if commands[1].Name != "Test" || filepath.Base(commands[1].Source) != "far.aqc" {
+		t.Errorf("Test should come from the farther file, got %+v", commands[1])
+	}
+	if !multipleSources(commands) {
+		t.Error("multipleSources() = false, expected true")
+	}
+}
+
+func TestLoadCommandFilesDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	near := filepath.Join(dir, "near.aqc")
+	far := filepath.Join(dir, "far.aqc")
+	if err := os.WriteFile(near, []byte("make build\n- Build: First\n---\nmake all\n- Build: Second\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.WriteFile(far, []byte("make far\n- Build: Far build\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	commands, diags, err := loadCommandFiles([]string{near, far}, "")
+	if err != nil {
+		t.Fatalf("loadCommandFiles() error = %v", err)
+	}
+	// Both commands of the nearer file are kept; the farther one is shadowed.
+	if len(commands) != 2 || commands[0].Cmd != "make build" || commands[1].Cmd != "make all" {
+		t.Fatalf("Expected both Build commands of near.aqc, got %+v", commands)
+	}
+	if len(diags) != 1 || diags[0].Line != 4 || !strings.Contains(diags[0].Reason, "already defined on line 1") {
+		t.Errorf("Expected a duplicate name diagnostic, got %v", diags)
+	}
+}
+
+func TestCheckNameFree(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, commandsFile)
+	if err := checkNameFree(path, "Build"); err != nil {
+		t.Errorf("checkNameFree() on a missing file = %v", err)
+	}
+	if err := os.WriteFile(path, []byte("make build\n- Build: Build it\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := checkNameFree(path, "Build"); err == nil {
+		t.Error("checkNameFree() accepted a name the file already has")
+	}
+	if err := checkNameFree(path, "Test"); err != nil {
+		t.Errorf("checkNameFree() = %v for a new name", err)
+	}
+}
+
+func TestLoadCommandFilesGlobal(t *testing.T) {
+	dir := t.TempDir()
+	project := filepath.Join(dir, commandsFile)
+	global := filepath.Join(dir, "global.aqc")
+	if err := os.WriteFile(project, []byte("make build\n- Build: Project build\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.WriteFile(global, []byte("make\n- Build: Global build\n---\nsudo vpn up\n- VPN: Connect\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	commands, _, err := loadCommandFiles([]string{project}, global)
+	if err != nil {
+		t.Fatalf("loadCommandFiles() error = %v", err)
+	}
+	if len(commands) != 2 {
+		t.Fatalf("Expected 2 commands, got %d", len(commands))
+	}
+	if commands[0].Description != "Project build" || commands[0].Global {
+		t.Errorf("Project command should win over the global one, got %+v", commands[0])
+	}
+	if commands[1].Name != "VPN" || !commands[1].Global {
+		t.Errorf("VPN should be a global command, got %+v", commands[1])
+	}
+	if multipleSources(commands) {
+		t.Error("multipleSources() should ignore global commands")
+	}
+}
+
+func TestParseGlobalFlags(t *testing.T) {
+	tests := []struct {
+		name         string
+		args         []string
+		expectedArgs []string
+		expectedFile string
+	}{
+		{"no flags", []string{"aqc", "list"}, []string{"aqc", "list"}, ""},
+		{"equals form", []string{"aqc", "--file=x.aqc", "list"}, []string{"aqc", "list"}, "x.aqc"},
+		{"separate value", []string{"aqc", "--file", "x.aqc"}, []string{"aqc"}, "x.aqc"},
+		{"after subcommand is left alone", []string{"aqc", "add", "--file=x.aqc"}, []string{"aqc", "add", "--file=x.aqc"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileOverride = ""
+			defer func() { fileOverride = "" }()
+			args := parseGlobalFlags(tt.args)
+			if !reflect.DeepEqual(args, tt.expectedArgs) {
+				t.Errorf("parseGlobalFlags() = %v, expected %v", args, tt.expectedArgs)
+			}
+			if fileOverride != tt.expectedFile {
+				t.Errorf("fileOverride = %q, expected %q", fileOverride, tt.expectedFile)
+			}
+		})
+	}
+}
+
+func TestGlobalCommandsFile(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", "/tmp/config")
+	expected := filepath.Join("/tmp/config", "aqc", "commands.aqc")
+	if path := globalCommandsFile(); path != expected {
+		t.Errorf("globalCommandsFile() = %q, expected %q", path, expected)
+	}
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// nodeKind tells what a node of a command file holds.
+type nodeKind int
+
+const (
+	nodeBlank nodeKind = iota
+	nodeComment
+	nodeHeader
+	nodeSeparator
+	nodeBlock
+	nodeDefaults
+	nodeGroup
+)
+
+// node is a run of lines of a command file. A block node holds a command
+// block together with the comments directly above and below it and those
+// inside it, and so does a defaults node for a @defaults block; every other
+// node is a single line. line is the 1-based line number of lines[0] in the
+// file as it was read. group is the name of the group a group header starts.
+type node struct {
+	kind    nodeKind
+	lines   []string
+	line    int
+	body    block
+	command *Command
+	group   string
+}
+
+// Document is a command file kept line by line, so commands can be changed,
+// removed and reordered while comments and blank lines are written back as
+// they were.
+type Document struct {
+	Path    string
+	Version int
+	// Defaults is what the @defaults block of the file sets, or nil.
+	Defaults *Defaults
+	nodes    []*node
+	// trailingNewline records whether the file ended with a newline.
+	trailingNewline bool
+}
+
+// ParseDocument parses the content of a command file into a Document.
+// Blocks that cannot be turned into a Command stay in the document but have
+// no command; the diagnostics explain why.
+func ParseDocument(file, data string) (*Document, []Diagnostic) {
+	doc := &Document{Path: file}
+	version, _, headerLine, err := splitHeader(data)
+	if err != nil {
+		return doc, []Diagnostic{{File: file, Line: headerLine, Column: 1, Reason: err.Error() + "; file skipped"}}
+	}
+	doc.Version = version
+	doc.trailingNewline = strings.HasSuffix(data, "\n")
+	doc.nodes = parseNodes(strings.TrimSuffix(data, "\n"), headerLine, version >= 2)
+
+	var diags []Diagnostic
+	group := ""
+	for _, n := range doc.nodes {
+		if n.kind == nodeGroup {
+			group = n.group
+		}
+		if n.kind != nodeBlock {
+			continue
+		}
+		if version >= 2 && isDefaultsBlock(n.body) {
+			n.kind = nodeDefaults
+			if doc.Defaults != nil {
+				d := blockDiag(n.body, 0, "only one "+defaultsMarker+" block is allowed per file; block ignored")
+				d.File = file
+				diags = append(diags, d)
+				continue
+			}
+			defaults, blockDiags := parseDefaults(n.body)
+			for _, d := range blockDiags {
+				d.File = file
+				diags = append(diags, d)
+			}
+			doc.Defaults = defaults
+			continue
+		}
+		c, blockDiags := parseBlock(n.body, version)
+		for _, d := range blockDiags {
+			d.File = file
+			diags = append(diags, d)
+		}
+		if c != nil {
+			c.Source = file
+			c.Group = group
+			n.command = c
+		}
+	}
+	return doc, diags
+}
+
+// ReadDocument reads and parses the command file at path. Unlike
+// ParseDocument it fails when the file cannot be used at all, so a document it
+// returns is always safe to save.
+func ReadDocument(path string) (*Document, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, _, err := splitHeader(string(data)); err != nil {
+		return nil, err
+	}
+	doc, _ := ParseDocument(path, string(data))
+	return doc, nil
+}
+
+// parseNodes splits data into nodes. headerLine is the line number of the
+// header, or 0 when there is none. Comments are lines starting with "#" outside
+// a fenced body. With groups set, lines like "[deploy]" outside a fenced body
+// are group headers; they end the block before them like a separator.
+func parseNodes(data string, headerLine int, groups bool) []*node {
+	lines := strings.Split(data, "\n")
+	kinds := make([]nodeKind, len(lines))
+	closing := ""
+	inBlock := false // a content line was seen since the last separator
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case closing != "":
+			kinds[i] = nodeBlock
+			if trimmed == closing {
+				closing = ""
+			}
+		case i+1 == headerLine:
+			kinds[i] = nodeHeader
+		case trimmed == "":
+			kinds[i] = nodeBlank
+		case trimmed == "---":
+			kinds[i] = nodeSeparator
+			inBlock = false
+		case strings.HasPrefix(trimmed, "#"):
+			kinds[i] = nodeComment
+		case groups && groupName(trimmed) != "":
+			kinds[i] = nodeGroup
+			inBlock = false
+		default:
+			kinds[i] = nodeBlock
+			if !inBlock {
+				closing = fenceOf(line)
+			}
+			inBlock = true
+		}
+	}
+
+	var nodes []*node
+	single := func(i int) {
+		n := &node{kind: kinds[i], lines: []string{lines[i]}, line: i + 1}
+		if n.kind == nodeGroup {
+			n.group = groupName(lines[i])
+		}
+		nodes = append(nodes, n)
+	}
+	divides := func(kind nodeKind) bool {
+		return kind == nodeSeparator || kind == nodeHeader || kind == nodeGroup
+	}
+	for start := 0; start < len(lines); {
+		if divides(kinds[start]) {
+			single(start)
+			start++
+			continue
+		}
+		// The region up to the next separator holds at most one block.
+		end := start
+		first, last := -1, -1
+		for ; end < len(lines) && !divides(kinds[end]); end++ {
+			if kinds[end] == nodeBlock {
+				if first == -1 {
+					first = end
+				}
+				last = end
+			}
+		}
+		if first == -1 {
+			for i := start; i < end; i++ {
+				single(i)
+			}
+			start = end
+			continue
+		}
+		// Comments directly above or below the block belong to it.
+		for first > start && kinds[first-1] == nodeComment {
+			first--
+		}
+		for last+1 < end && kinds[last+1] == nodeComment {
+			last++
+		}
+		for i := start; i < first; i++ {
+			single(i)
+		}
+		n := &node{kind: nodeBlock, lines: append([]string(nil), lines[first:last+1]...), line: first + 1}
+		for i := first; i <= last; i++ {
+			if kinds[i] == nodeBlock {
+				n.body.lines = append(n.body.lines, lines[i])
+				n.body.lineNos = append(n.body.lineNos, i+1)
+			}
+		}
+		nodes = append(nodes, n)
+		for i := last + 1; i < end; i++ {
+			single(i)
+		}
+		start = end
+	}
+	return nodes
+}
+
+// Commands returns the commands of the document in file order, each with the
+// defaults of the document.
+func (d *Document) Commands() []Command {
+	var commands []Command
+	for _, n := range d.nodes {
+		if n.kind == nodeBlock && n.command != nil {
+			c := *n.command
+			c.Defaults = d.Defaults
+			commands = append(commands, c)
+		}
+	}
+	return commands
+}
+
+// String returns the content of the document as it would be saved.
+func (d *Document) String() string {
+	var lines []string
+	for _, n := range d.nodes {
+		lines = append(lines, n.lines...)
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	s := strings.Join(lines, "\n")
+	if d.trailingNewline {
+		s += "\n"
+	}
+	return s
+}
+
+// Save writes the document back to its file.
+func (d *Document) Save() error {
+	return writeFileAtomic(d.Path, []byte(d.String()))
+}
+
+// find returns the index of the node holding c. It fails when the block is no
+// longer where it was when c was loaded.
+func (d *Document) find(c Command) (int, error) {
+	for i, n := range d.nodes {
+		if fc := n.command; fc != nil && fc.Line == c.Line && fc.EndLine == c.EndLine && fc.Name == c.Name && fc.Cmd == c.Cmd {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("%s changed since it was loaded; please try again", d.Path)
+}
+
+// Replace swaps the block of old for updated. Comments above the block stay
+// above it; comments inside or below it are kept after the new name line.
+func (d *Document) Replace(old, updated Command) error {
+	i, err := d.find(old)
+	if err != nil {
+		return err
+	}
+	if version := requiredVersion(updated); d.Version < version {
+		return fmt.Errorf("%s uses file format v%d; run 'aqc migrate' to use attributes such as dir", d.Path, d.Version)
+	}
+	n := d.nodes[i]
+	content := make(map[int]bool, len(n.body.lineNos))
+	for _, lineNo := range n.body.lineNos {
+		content[lineNo] = true
+	}
+	var leading, trailing []string
+	for j, line := range n.lines {
+		switch lineNo := n.line + j; {
+		case content[lineNo], strings.TrimSpace(line) == "":
+		case lineNo < n.body.lineNos[0]:
+			leading = append(leading, line)
+		default:
+			trailing = append(trailing, line)
+		}
+	}
+	lines := append(leading, strings.Split(encodeBlock(updated), "\n")...)
+	n.lines = append(lines, trailing...)
+	n.command = &updated
+	return nil
+}
+
+// Remove deletes the block of c, with its comments and the separator that
+// closes it.
+func (d *Document) Remove(c Command) error {
+	i, err := d.find(c)
+	if err != nil {
+		return err
+	}
+	end := i + 1
+	next := end
+	for next < len(d.nodes) && d.nodes[next].kind == nodeBlank {
+		next++
+	}
+	if next < len(d.nodes) && d.nodes[next].kind == nodeSeparator {
+		end = next + 1
+	}
+	d.nodes = append(d.nodes[:i:i], d.nodes[end:]...)
+	return nil
+}
+
+// Sort reorders the blocks so that less holds between neighbours. Each block
+// moves together with its comments and everything after it up to the next
+// block; the lines before the first block stay at the top. Blocks that did not
+// parse go last. Blocks stay in their group.
+func (d *Document) Sort(less func(a, b Command) bool) {
+	var nodes []*node
+	start := 0
+	for i := 0; i <= len(d.nodes); i++ {
+		if i == len(d.nodes) || d.nodes[i].kind == nodeGroup {
+			nodes = append(nodes, sortSection(d.nodes[start:i], less)...)
+			if i < len(d.nodes) {
+				nodes = append(nodes, d.nodes[i])
+			}
+			start = i + 1
+		}
+	}
+	d.nodes = nodes
+}
+
+// sortSection sorts the blocks of a run of nodes without group headers, as
+// described for Sort.
+func sortSection(section []*node, less func(a, b Command) bool) []*node {
+	first := -1
+	for i, n := range section {
+		if n.kind == nodeBlock {
+			first = i
+			break
+		}
+	}
+	if first == -1 {
+		return section
+	}
+	// Blank lines at the end stay there, apart from the group header after.
+	end := len(section)
+	for end > first && section[end-1].kind == nodeBlank {
+		end--
+	}
+	var units [][]*node
+	for _, n := range section[first:end] {
+		if n.kind == nodeBlock {
+			units = append(units, nil)
+		}
+		units[len(units)-1] = append(units[len(units)-1], n)
+	}
+	sort.SliceStable(units, func(i, j int) bool {
+		a, b := units[i][0].command, units[j][0].command
+		if a == nil || b == nil {
+			return a != nil
+		}
+		return less(*a, *b)
+	})
+
+	nodes := append([]*node(nil), section[:first]...)
+	for i, unit := range units {
+		hasSeparator := false
+		for _, n := range unit {
+			hasSeparator = hasSeparator || n.kind == nodeSeparator
+		}
+		if !hasSeparator && i < len(units)-1 {
+			// A block that used to be last may lack its separator.
+			separator := &node{kind: nodeSeparator, lines: []string{"---"}}
+			unit = append([]*node{unit[0], separator}, unit[1:]...)
+		}
+		nodes = append(nodes, unit...)
+	}
+	return append(nodes, section[end:]...)
+}
+
+// hasGroups reports whether the document has group headers.
+func (d *Document) hasGroups() bool {
+	for _, n := range d.nodes {
+		if n.kind == nodeGroup {
+			return true
+		}
+	}
+	return false
+}
+
+// Append adds c as a new block at the end of its group or, when it has no
+// group, before the first group header. A group that does not exist yet gets
+// a header at the end of the file.
+func (d *Document) Append(c Command) error {
+	if version := requiredVersion(c); d.Version < version {
+		return fmt.Errorf("%s uses file format v%d; run 'aqc migrate' to use attributes such as dir", d.Path, d.Version)
+	}
+	at, firstHeader, hasGroup := len(d.nodes), -1, false
+	group := ""
+	for i, n := range d.nodes {
+		switch {
+		case n.kind == nodeGroup:
+			group = n.group
+			if firstHeader == -1 {
+				firstHeader = i
+			}
+			if group == c.Group {
+				hasGroup = true
+				at = i + 1
+			}
+		case n.kind == nodeBlock && group == c.Group:
+			at = i + 1
+			for at < len(d.nodes) && d.nodes[at].kind == nodeBlank {
+				at++
+			}
+			if at < len(d.nodes) && d.nodes[at].kind == nodeSeparator {
+				at++
+			} else {
+				at = i + 1
+			}
+		}
+	}
+	if c.Group == "" && firstHeader != -1 && at > firstHeader {
+		at = firstHeader
+	}
+
+	var added []*node
+	if c.Group != "" && !hasGroup {
+		at = len(d.nodes)
+		if at > 0 && d.nodes[at-1].kind != nodeBlank && d.nodes[at-1].kind != nodeHeader {
+			added = append(added, &node{kind: nodeBlank, lines: []string{""}})
+		}
+		added = append(added, &node{kind: nodeGroup, lines: []string{"[" + c.Group + "]"}, group: c.Group})
+	}
+	// A block before the new one needs a separator to end it.
+	prev := at - 1
+	for prev >= 0 && d.nodes[prev].kind == nodeBlank {
+		prev--
+	}
+	if len(added) == 0 && prev >= 0 && (d.nodes[prev].kind == nodeBlock || d.nodes[prev].kind == nodeDefaults) {
+		added = append(added, &node{kind: nodeSeparator, lines: []string{"---"}})
+	}
+	added = append(added,
+		&node{kind: nodeBlock, lines: strings.Split(encodeBlock(c), "\n"), command: &c},
+		&node{kind: nodeSeparator, lines: []string{"---"}})
+
+	d.nodes = append(d.nodes[:at:at], append(added, d.nodes[at:]...)...)
+	d.trailingNewline = true
+	return nil
+}
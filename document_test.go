@@ -0,0 +1,230 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+const documentTestContent = `#aqc v2
+# Commands for the web team.
+
+# Lists files.
+ls -la
+- List: List files
+# GNU ls only
+---
+
+# ---- Build ----
+
+` + "```" + `
+# not a comment: part of the script
+make build
+` + "```" + `
+- Build: Build the app
+---
+# Where am I?
+pwd
+- Dir
+`
+
+func TestParseDocument(t *testing.T) {
+	doc, diags := ParseDocument("f", documentTestContent)
+	if len(diags) != 0 {
+		t.Fatalf("Unexpected diagnostics: %v", diags)
+	}
+	if doc.Version != 2 {
+		t.Errorf("Version = %d, expected 2", doc.Version)
+	}
+	commands := doc.Commands()
+	if len(commands) != 3 {
+		t.Fatalf("Expected 3 commands, got %d", len(commands))
+	}
+	if commands[1].Cmd != "# not a comment: part of the script\nmake build" {
+		t.Errorf("Fenced command = %q", commands[1].Cmd)
+	}
+
+	var kinds []nodeKind
+	for _, n := range doc.nodes {
+		kinds = append(kinds, n.kind)
+	}
+	expected := []nodeKind{
+		nodeHeader, nodeComment, nodeBlank,
+		nodeBlock, nodeSeparator,
+		nodeBlank, nodeComment, nodeBlank,
+		nodeBlock, nodeSeparator,
+		nodeBlock,
+	}
+	if !reflect.DeepEqual(kinds, expected) {
+		t.Errorf("Node kinds = %v, expected %v", kinds, expected)
+	}
+	if doc.String() != documentTestContent {
+		t.Errorf("String() =\n%q\nexpected the input back", doc.String())
+	}
+}
+
+func TestDocumentRoundTripProperty(t *testing.T) {
+	lines := []string{"", " ", "---", "# note", "#aqc v2", "ls", "- Name: desc", "```", "````", "  echo hi", "key: value"}
+	f := func(seed int64) bool {
+		r := rand.New(rand.NewSource(seed))
+		var b strings.Builder
+		for n := r.Intn(20); n > 0; n-- {
+			b.WriteString(lines[r.Intn(len(lines))])
+			if r.Intn(8) > 0 {
+				b.WriteString("\n")
+			}
+		}
+		doc, _ := ParseDocument("f", b.String())
+		if doc.Version == 0 {
+			return true
+		}
+		return doc.String() == b.String()
+	}
+	if err := quick.Check(f, &quick.Config{MaxCount: 2000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDocumentReplaceKeepsComments(t *testing.T) {
+	doc, _ := ParseDocument("f", documentTestContent)
+	old := doc.Commands()[0]
+	updated := old
+	updated.Cmd = "ls -lah"
+	if err := doc.Replace(old, updated); err != nil {
+		t.Fatalf("Replace() error = %v", err)
+	}
+	expected := strings.Replace(documentTestContent, "ls -la\n", "ls -lah\n", 1)
+	if doc.String() != expected {
+		t.Errorf("String() =\n%s\nexpected\n%s", doc.String(), expected)
+	}
+}
+
+func TestDocumentRemoveTakesComments(t *testing.T) {
+	doc, _ := ParseDocument("f", documentTestContent)
+	if err := doc.Remove(doc.Commands()[0]); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	expected := "#aqc v2\n# Commands for the web team.\n\n\n# ---- Build ----\n\n"
+	if !strings.HasPrefix(doc.String(), expected) {
+		t.Errorf("String() =\n%q\nexpected it to start with\n%q", doc.String(), expected)
+	}
+	if strings.Contains(doc.String(), "GNU ls") {
+		t.Error("The removed block's comments were kept")
+	}
+}
+
+func TestDocumentSort(t *testing.T) {
+	doc, _ := ParseDocument("f", documentTestContent)
+	doc.Sort(byName)
+
+	sorted, diags := ParseCommandFile("f", doc.String())
+	if len(diags) != 0 {
+		t.Fatalf("Unexpected diagnostics after sorting: %v\n%s", diags, doc.String())
+	}
+	var names []string
+	for _, c := range sorted {
+		names = append(names, c.Name)
+	}
+	if expected := []string{"Build", "Dir", "List"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Names after sorting = %v, expected %v", names, expected)
+	}
+
+	out := doc.String()
+	if !strings.HasPrefix(out, "#aqc v2\n# Commands for the web team.\n\n") {
+		t.Errorf("The file header moved:\n%s", out)
+	}
+	if !strings.Contains(out, "# Where am I?\npwd\n- Dir\n---\n") {
+		t.Errorf("The last block did not get a separator or lost its comment:\n%s", out)
+	}
+	if !strings.Contains(out, "# Lists files.\nls -la\n- List: List files\n# GNU ls only\n---\n") {
+		t.Errorf("A block lost its comments:\n%s", out)
+	}
+}
+
+const groupTestContent = `#aqc v2
+make
+- Build
+---
+
+[deploy]
+kubectl rollout undo
+- Rollback
+---
+kubectl apply
+- Apply
+---
+
+[db]
+psql
+- Shell
+`
+
+func TestDocumentGroups(t *testing.T) {
+	doc, diags := ParseDocument("f", groupTestContent)
+	if len(diags) != 0 {
+		t.Fatalf("ParseDocument() diagnostics = %v", diags)
+	}
+	groups := make(map[string]string)
+	for _, c := range doc.Commands() {
+		groups[c.Name] = c.Group
+	}
+	expected := map[string]string{"Build": "", "Rollback": "deploy", "Apply": "deploy", "Shell": "db"}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Errorf("Groups = %v, expected %v", groups, expected)
+	}
+
+	t.Run("sort keeps groups", func(t *testing.T) {
+		doc, _ := ParseDocument("f", groupTestContent)
+		doc.Sort(byName)
+		expected := strings.Replace(groupTestContent,
+			"kubectl rollout undo\n- Rollback\n---\nkubectl apply\n- Apply\n---\n",
+			"kubectl apply\n- Apply\n---\nkubectl rollout undo\n- Rollback\n---\n", 1)
+		if doc.String() != expected {
+			t.Errorf("String() =\n%s\nexpected\n%s", doc.String(), expected)
+		}
+	})
+
+	tests := []struct {
+		name     string
+		command  Command
+		expected string
+	}{
+		{
+			"ungrouped goes before the first group",
+			Command{Name: "Test", Cmd: "make test"},
+			strings.Replace(groupTestContent, "- Build\n---\n", "- Build\n---\nmake test\n- Test\n---\n", 1),
+		},
+		{
+			"grouped goes after the last command of its group",
+			Command{Name: "Status", Cmd: "kubectl get pods", Group: "deploy"},
+			strings.Replace(groupTestContent, "- Apply\n---\n", "- Apply\n---\nkubectl get pods\n- Status\n---\n", 1),
+		},
+		{
+			"last block gets a separator",
+			Command{Name: "Dump", Cmd: "pg_dump", Group: "db"},
+			groupTestContent + "---\npg_dump\n- Dump\n---\n",
+		},
+		{
+			"new group goes last",
+			Command{Name: "Up", Cmd: "vagrant up", Group: "vm"},
+			groupTestContent + "\n[vm]\nvagrant up\n- Up\n---\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, _ := ParseDocument("f", groupTestContent)
+			if err := doc.Append(tt.command); err != nil {
+				t.Fatalf("Append() error = %v", err)
+			}
+			if doc.String() != tt.expected {
+				t.Errorf("String() =\n%s\nexpected\n%s", doc.String(), tt.expected)
+			}
+			commands, diags := ParseCommandFile("f", doc.String())
+			if len(diags) != 0 || len(commands) != 5 {
+				t.Errorf("Reparsed %d commands, diagnostics %v", len(commands), diags)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// EditSubcommand handles the "edit" subcommand to change a saved command in
+// its file.
+func EditSubcommand() {
+	editCmd := flag.NewFlagSet("edit", flag.ExitOnError)
+	cmdPtr := editCmd.String("cmd", "", "The new command to run")
+	namePtr := editCmd.String("name", "", "The new name of the command")
+	descPtr := editCmd.String("desc", "", "The new description of the command")
+	dirPtr := editCmd.String("dir", "", "The new directory to run the command in, or \"\" to use the file's directory")
+	shellPtr := editCmd.String("shell", "", "The new shell to run the command with, or \"\" for the default")
+	tagsPtr := editCmd.String("tags", "", "Replace the tags with this comma-separated list (--tags= clears them)")
+	confirmPtr := editCmd.String("confirm", "", "true to always ask before running, false to never ask, \"\" to decide from the command")
+	var env, envFiles, needs listFlags
+	editCmd.Var(&env, "env", "Replace the environment variables with NAME=value (repeatable; --env= clears them)")
+	editCmd.Var(&envFiles, "env-file", "Replace the .env files to load (repeatable; --env-file= clears them)")
+	editCmd.Var(&needs, "needs", "Replace the commands that must succeed first (repeatable; --needs= clears them)")
+	cmdFilePtr := editCmd.String("cmd-file", "", "Read a (multi-line) command from a file, or - for stdin")
+	editCmd.Usage = func() {
+		fmt.Fprintln(editCmd.Output(), "Usage: aqc edit <name|number> [--cmd=...] [--name=...] [--desc=...] [--dir=...] [--env=...]")
+		editCmd.PrintDefaults()
+	}
+	query := parseTarget(editCmd)
+
+	commands, _ := LoadCommands()
+	index, err := findCommand(commands, query)
+	if err != nil {
+		fmt.Printf("%sError: %v%s\n", ColorRed, err, ColorReset)
+		os.Exit(1)
+	}
+	old := commands[index]
+	updated := old
+
+	// Only the flags that were given change the command.
+	editCmd.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "cmd":
+			updated.Cmd = *cmdPtr
+		case "name":
+			updated.Name = *namePtr
+		case "desc":
+			updated.Description = *descPtr
+		case "dir":
+			updated.Dir = *dirPtr
+		case "env":
+			updated.Env = nonEmptyValues(env)
+		case "env-file":
+			updated.EnvFiles = nonEmptyValues(envFiles)
+		case "shell":
+			updated.Shell = *shellPtr
+		case "needs":
+			updated.Needs = nonEmptyValues(needs)
+		case "tags":
+			updated.Tags = splitTags(*tagsPtr)
+		case "confirm":
+			updated.Confirm, err = parseConfirm(*confirmPtr)
+		}
+	})
+	if err == nil {
+		err = checkCommon(updated)
+	}
+	if err != nil {
+		fmt.Printf("%sError: %v%s\n", ColorRed, err, ColorReset)
+		os.Exit(1)
+	}
+	if *cmdFilePtr != "" {
+		body, err := readCmdFile(*cmdFilePtr)
+		if err != nil {
+			fmt.Printf("%sError reading command file: %v%s\n", ColorRed, err, ColorReset)
+			os.Exit(1)
+		}
+		updated.Cmd = body
+	}
+	if updated.Cmd == "" || updated.Name == "" {
+		fmt.Println(ColorRed + "Error: the command and its name cannot be empty." + ColorReset)
+		os.Exit(1)
+	}
+
+	if err := UpdateCommand(old, updated); err != nil {
+		fmt.Printf("%sError updating command: %v%s\n", ColorRed, err, ColorReset)
+		os.Exit(1)
+	}
+	fmt.Println(ColorGreen + "Command updated in " + old.Source + "!" + ColorReset)
+}
+
+// RemoveSubcommand handles the "remove" subcommand to delete a saved command
+// from its file.
+func RemoveSubcommand() {
+	removeCmd := flag.NewFlagSet("remove", flag.ExitOnError)
+	removeCmd.Usage = func() {
+		fmt.Fprintln(removeCmd.Output(), "Usage: aqc remove <name|number>")
+		removeCmd.PrintDefaults()
+	}
+	query := parseTarget(removeCmd)
+
+	commands, _ := LoadCommands()
+	index, err := findCommand(commands, query)
+	if err != nil {
+		fmt.Printf("%sError: %v%s\n", ColorRed, err, ColorReset)
+		os.Exit(1)
+	}
+	c := commands[index]
+	if err := RemoveCommand(c); err != nil {
+		fmt.Printf("%sError removing command: %v%s\n", ColorRed, err, ColorReset)
+		os.Exit(1)
+	}
+	fmt.Printf("%sRemoved %q from %s.%s\n", ColorGreen, c.Name, c.Source, ColorReset)
+}
+
+// parseTarget parses the subcommand's flags, which may come before or after
+// the command name or number, and returns that name or number.
+func parseTarget(fs *flag.FlagSet) string {
+	fs.Parse(os.Args[2:])
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	query := fs.Arg(0)
+	fs.Parse(fs.Args()[1:])
+	return query
+}
+
+// UpdateCommand replaces the block of old in its file with updated.
+// Everything else in the file, including comments, is kept as it was.
+func UpdateCommand(old, updated Command) error {
+	if formatForPath(old.Source).name != "aqc" {
+		return changeEntries(old, func(commands []Command, i int) []Command {
+			commands[i] = updated
+			return commands
+		})
+	}
+	doc, err := ReadDocument(old.Source)
+	if err != nil {
+		return err
+	}
+	if err := doc.Replace(old, updated); err != nil {
+		return err
+	}
+	return doc.Save()
+}
+
+// RemoveCommand deletes the block of c, and the separator after it, from its
+// file.
+func RemoveCommand(c Command) error {
+	if formatForPath(c.Source).name != "aqc" {
+		return changeEntries(c, func(commands []Command, i int) []Command {
+			return append(commands[:i], commands[i+1:]...)
+		})
+	}
+	doc, err := ReadDocument(c.Source)
+	if err != nil {
+		return err
+	}
+	if err := doc.Remove(c); err != nil {
+		return err
+	}
+	return doc.Save()
+}
+
+// nonEmptyValues returns the values of a repeatable flag without the empty
+// ones, which only serve to clear the list.
+func nonEmptyValues(values []string) []string {
+	var kept []string
+	for _, v := range values {
+		if v != "" {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+// changeEntries applies change to the commands of the YAML, TOML or JSON file
+// holding c, where i is the index of c, and writes the file back.
+func changeEntries(c Command, change func(commands []Command, i int) []Command) error {
+	commands, err := readEntries(c.Source)
+	if err != nil {
+		return err
+	}
+	for i, fc := range commands {
+		if fc.Line == c.Line && fc.Name == c.Name && fc.Cmd == c.Cmd {
+			return writeEntries(c.Source, change(commands, i))
+		}
+	}
+	return fmt.Errorf("%s changed since it was loaded; please try again", c.Source)
+}
+
+// writeFileAtomic replaces the file at path with data by writing a temporary
+// file next to it and renaming it into place, so readers never see a
+// partially written file. The original file mode is kept.
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const editTestContent = `ls -la
+- List Files: List all files
+---
+
+` + "```" + `
+make build
+make deploy
+` + "```" + `
+- Deploy: Build and deploy
+
+---
+pwd
+- Current Dir: Show current directory
+`
+
+func loadTestFile(t *testing.T, path string) []Command {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	commands, diags := ParseCommandFile(path, string(data))
+	if len(diags) != 0 {
+		t.Fatalf("Unexpected diagnostics: %v", diags)
+	}
+	return commands
+}
+
+func writeTestFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), commandsFile)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	return path
+}
+
+func TestUpdateCommand(t *testing.T) {
+	path := writeTestFile(t, editTestContent)
+	commands := loadTestFile(t, path)
+
+	updated := commands[1]
+	updated.Name = "Ship"
+	updated.Cmd = "make ship"
+	if err := UpdateCommand(commands[1], updated); err != nil {
+		t.Fatalf("UpdateCommand() error = %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	expected := "ls -la\n- List Files: List all files\n---\n\nmake ship\n- Ship: Build and deploy\n\n---\npwd\n- Current Dir: Show current directory\n"
+	if string(data) != expected {
+		t.Errorf("File after update =\n%q\nexpected\n%q", data, expected)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("File mode = %v, expected 0600", info.Mode().Perm())
+	}
+}
+
+func TestRemoveCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		index    int
+		expected string
+	}{
+		{
+			name:     "first block",
+			index:    0,
+			expected: "\n```\nmake build\nmake deploy\n```\n- Deploy: Build and deploy\n\n---\npwd\n- Current Dir: Show current directory\n",
+		},
+		{
+			name:     "middle block with blank line before separator",
+			index:    1,
+			expected: "ls -la\n- List Files: List all files\n---\n\npwd\n- Current Dir: Show current directory\n",
+		},
+		{
+			name:     "last block without separator",
+			index:    2,
+			expected: "ls -la\n- List Files: List all files\n---\n\n```\nmake build\nmake deploy\n```\n- Deploy: Build and deploy\n\n---\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestFile(t, editTestContent)
+			commands := loadTestFile(t, path)
+			if err := RemoveCommand(commands[tt.index]); err != nil {
+				t.Fatalf("RemoveCommand() error = %v", err)
+			}
+			data, _ := os.ReadFile(path)
+			if string(data) != tt.expected {
+				t.Errorf("File after removal =\n%q\nexpected\n%q", data, tt.expected)
+			}
+			if remaining := loadTestFile(t, path); len(remaining) != 2 {
+				t.Errorf("Expected 2 commands after removal, got %d", len(remaining))
+			}
+		})
+	}
+}
+
+func TestRemoveCommandDetectsChangedFile(t *testing.T) {
+	path := writeTestFile(t, editTestContent)
+	commands := loadTestFile(t, path)
+
+	if err := os.WriteFile(path, []byte("echo new\n- New\n---\n"+editTestContent), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := RemoveCommand(commands[0]); err == nil {
+		t.Error("RemoveCommand() should fail when the block moved")
+	}
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Loader reads and writes one kind of command file, so that new file formats
+// can be added without touching the rest of aqc.
+type Loader interface {
+	// Decode parses the content of a command file. Entries that cannot be
+	// turned into a Command are skipped and reported as diagnostics.
+	Decode(file string, data []byte) ([]Command, []Diagnostic)
+	// Encode renders commands as the complete content of a command file.
+	Encode(commands []Command) ([]byte, error)
+}
+
+// fileFormat is a command file format: its name, the extension added to
+// .commands.aqc for files in that format, other extensions its files may
+// have, and its loader.
+type fileFormat struct {
+	name   string
+	ext    string
+	alt    []string
+	loader Loader
+}
+
+// fileFormats lists the supported formats in the order files are looked for.
+// The block format comes first and is used for any path that does not end in
+// the extension of another format.
+var fileFormats = []fileFormat{
+	{"aqc", "", nil, aqcLoader{}},
+	{"yaml", ".yaml", []string{".yml"}, yamlLoader{}},
+	{"toml", ".toml", nil, tomlLoader{}},
+	{"json", ".json", nil, jsonLoader{}},
+}
+
+// shortCommandsFile is the shorter base name that YAML, TOML and JSON
+// command files may use instead of .commands.aqc, as in .aqc.toml.
+const shortCommandsFile = ".aqc"
+
+// fileNames returns the names a command file in format f is looked for
+// under, in that order: .commands.aqc plus each extension of the format, then
+// the same with the shorter .aqc base name. Block files are only ever called
+// .commands.aqc.
+func (f fileFormat) fileNames() []string {
+	if f.ext == "" {
+		return []string{commandsFile}
+	}
+	var names []string
+	for _, base := range []string{commandsFile, shortCommandsFile} {
+		for _, ext := range append([]string{f.ext}, f.alt...) {
+			names = append(names, base+ext)
+		}
+	}
+	return names
+}
+
+// extOf returns the extension of f that path ends in, or "" if none does.
+func (f fileFormat) extOf(path string) string {
+	if f.ext == "" {
+		return ""
+	}
+	for _, ext := range append([]string{f.ext}, f.alt...) {
+		if strings.HasSuffix(path, ext) {
+			return ext
+		}
+	}
+	return ""
+}
+
+// formatForPath returns the format of the command file at path.
+func formatForPath(path string) fileFormat {
+	for _, f := range fileFormats[1:] {
+		if f.extOf(path) != "" {
+			return f
+		}
+	}
+	return fileFormats[0]
+}
+
+// formatNamed returns the format called name.
+func formatNamed(name string) (fileFormat, error) {
+	var names []string
+	for _, f := range fileFormats {
+		if f.name == name {
+			return f, nil
+		}
+		names = append(names, f.name)
+	}
+	return fileFormat{}, fmt.Errorf("unknown format %q (use %s)", name, strings.Join(names, ", "))
+}
+
+// readEntries reads the commands of a YAML, TOML or JSON file. It fails when
+// some entries could not be read, since writing the file back would drop them.
+func readEntries(path string) ([]Command, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	commands, diags := formatForPath(path).loader.Decode(path, data)
+	if len(diags) > 0 {
+		return nil, fmt.Errorf("%s has %d problem(s); fix the ones 'aqc lint' reports first", path, len(diags))
+	}
+	return commands, nil
+}
+
+// writeEntries replaces the content of a YAML, TOML or JSON file with commands.
+func writeEntries(path string, commands []Command) error {
+	data, err := formatForPath(path).loader.Encode(commands)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// aqcLoader reads and writes the block format of .commands.aqc files.
+type aqcLoader struct{}
+
+func (aqcLoader) Decode(file string, data []byte) ([]Command, []Diagnostic) {
+	return ParseCommandFile(file, string(data))
+}
+
+func (aqcLoader) Encode(commands []Command) ([]byte, error) {
+	var b strings.Builder
+	b.WriteString(formatHeader(formatVersion) + "\n")
+	if d := commonDefaults(commands); d != nil {
+		b.WriteString(encodeDefaults(*d) + "\n---\n")
+	}
+	group := ""
+	for _, c := range byGroup(commands) {
+		if c.Group != group {
+			group = c.Group
+			b.WriteString("\n[" + group + "]\n")
+		}
+		b.WriteString(formatBlock(c))
+	}
+	return []byte(b.String()), nil
+}
+
+// byGroup orders commands the way a command file holds them: the commands
+// without a group first, then each group in the order it first appears.
+func byGroup(commands []Command) []Command {
+	var names []string
+	members := make(map[string][]Command)
+	for _, c := range commands {
+		if _, ok := members[c.Group]; !ok && c.Group != "" {
+			names = append(names, c.Group)
+		}
+		members[c.Group] = append(members[c.Group], c)
+	}
+	ordered := members[""]
+	for _, name := range names {
+		ordered = append(ordered, members[name]...)
+	}
+	return ordered
+}
+
+// fileCommand is how a command is stored in the YAML, TOML and JSON formats.
+type fileCommand struct {
+	Name        fileText   `json:"name" yaml:"name" toml:"name"`
+	Description fileText   `json:"description,omitempty" yaml:"description,omitempty" toml:"description,omitempty"`
+	Cmd         fileText   `json:"cmd" yaml:"cmd" toml:"cmd"`
+	Dir         fileText   `json:"dir,omitempty" yaml:"dir,omitempty" toml:"dir,omitempty"`
+	Env         []fileText `json:"env,omitempty" yaml:"env,omitempty" toml:"env,omitempty"`
+	EnvFiles    []fileText `json:"env_file,omitempty" yaml:"env_file,omitempty" toml:"env_file,omitempty"`
+	Shell       fileText   `json:"shell,omitempty" yaml:"shell,omitempty" toml:"shell,omitempty"`
+	Needs       []fileText `json:"needs,omitempty" yaml:"needs,omitempty" toml:"needs,omitempty"`
+	Group       fileText   `json:"group,omitempty" yaml:"group,omitempty" toml:"group,omitempty"`
+	Tags        []fileText `json:"tags,omitempty" yaml:"tags,omitempty" toml:"tags,omitempty"`
+	Confirm     *bool      `json:"confirm,omitempty" yaml:"confirm,omitempty" toml:"confirm,omitempty"`
+}
+
+// fileDefaults is how a @defaults block is stored in the YAML, TOML and JSON
+// formats.
+type fileDefaults struct {
+	Env      []fileText `json:"env,omitempty" yaml:"env,omitempty" toml:"env,omitempty"`
+	EnvFiles []fileText `json:"env_file,omitempty" yaml:"env_file,omitempty" toml:"env_file,omitempty"`
+	Shell    fileText   `json:"shell,omitempty" yaml:"shell,omitempty" toml:"shell,omitempty"`
+}
+
+// fileText is a string in a YAML, TOML or JSON command file. Multi-line text
+// is written to YAML as a block scalar, except when it starts with white space:
+// yaml.v3 writes those block scalars incorrectly, so they are double-quoted
+// instead.
+type fileText string
+
+func (t fileText) MarshalYAML() (any, error) {
+	n := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: string(t)}
+	if strings.TrimLeftFunc(n.Value, unicode.IsSpace) != n.Value {
+		n.Style = yaml.DoubleQuotedStyle
+	}
+	return n, nil
+}
+
+// commandList is the top level of a YAML, TOML or JSON command file.
+type commandList struct {
+	Defaults *fileDefaults `json:"defaults,omitempty" yaml:"defaults,omitempty" toml:"defaults,omitempty"`
+	Commands []fileCommand `json:"commands" yaml:"commands" toml:"commands"`
+}
+
+func newCommandList(commands []Command) commandList {
+	list := commandList{Commands: make([]fileCommand, len(commands))}
+	if d := commonDefaults(commands); d != nil {
+		list.Defaults = &fileDefaults{Env: fileTexts(d.Env), EnvFiles: fileTexts(d.EnvFiles), Shell: fileText(d.Shell)}
+	}
+	for i, c := range commands {
+		list.Commands[i] = fileCommand{
+			Name:        fileText(c.Name),
+			Description: fileText(c.Description),
+			Cmd:         fileText(c.Cmd),
+			Dir:         fileText(c.Dir),
+			Env:         fileTexts(c.Env),
+			EnvFiles:    fileTexts(c.EnvFiles),
+			Shell:       fileText(c.Shell),
+			Needs:       fileTexts(c.Needs),
+			Group:       fileText(c.Group),
+			Tags:        fileTexts(c.Tags),
+			Confirm:     c.Confirm,
+		}
+	}
+	return list
+}
+
+// commonDefaults returns the defaults the commands were read with. Commands
+// of one file all share the same defaults.
+func commonDefaults(commands []Command) *Defaults {
+	for _, c := range commands {
+		if c.Defaults != nil {
+			return c.Defaults
+		}
+	}
+	return nil
+}
+
+// fileTexts converts strings to fileText values, keeping nil as nil.
+func fileTexts(values []string) []fileText {
+	if values == nil {
+		return nil
+	}
+	texts := make([]fileText, len(values))
+	for i, v := range values {
+		texts[i] = fileText(v)
+	}
+	return texts
+}
+
+// textValues converts fileText values back to strings, keeping nil as nil.
+func textValues(texts []fileText) []string {
+	if texts == nil {
+		return nil
+	}
+	values := make([]string, len(texts))
+	for i, t := range texts {
+		values[i] = string(t)
+	}
+	return values
+}
+
+// decodeList turns the entries of a decoded file into commands. Line and
+// EndLine hold the 1-based position of the entry in the list.
+func decodeList(file string, list commandList) ([]Command, []Diagnostic) {
+	var commands []Command
+	var diags []Diagnostic
+	var defaults *Defaults
+	if fd := list.Defaults; fd != nil {
+		defaults = &Defaults{Env: textValues(fd.Env), EnvFiles: textValues(fd.EnvFiles), Shell: string(fd.Shell)}
+		if err := checkCommon(Command{Env: defaults.Env, Shell: defaults.Shell}); err != nil {
+			diags = append(diags, Diagnostic{File: file, Reason: "defaults: " + err.Error() + "; defaults ignored"})
+			defaults = nil
+		}
+	}
+	for i, fc := range list.Commands {
+		if fc.Name == "" || fc.Cmd == "" {
+			diags = append(diags, Diagnostic{File: file, Reason: fmt.Sprintf("command %d needs both a name and a cmd; skipped", i+1)})
+			continue
+		}
+		c := Command{
+			Cmd:         string(fc.Cmd),
+			Name:        string(fc.Name),
+			Description: string(fc.Description),
+			Dir:         string(fc.Dir),
+			Env:         textValues(fc.Env),
+			EnvFiles:    textValues(fc.EnvFiles),
+			Shell:       string(fc.Shell),
+			Needs:       textValues(fc.Needs),
+			Group:       string(fc.Group),
+			Tags:        textValues(fc.Tags),
+			Confirm:     fc.Confirm,
+			Defaults:    defaults,
+			Source:      file,
+			Line:        i + 1,
+			EndLine:     i + 1,
+		}
+		if err := checkCommon(c); err != nil {
+			diags = append(diags, Diagnostic{File: file, Reason: fmt.Sprintf("command %d: %v; skipped", i+1, err)})
+			continue
+		}
+		commands = append(commands, c)
+	}
+	return commands, diags
+}
+
+// checkCommon checks the fields of c that the block format checks while
+// parsing: the env entries, the shell, the tags and the group.
+func checkCommon(c Command) error {
+	if err := checkEnv(c.Env); err != nil {
+		return err
+	}
+	if c.Shell != "" {
+		if err := checkShell(c.Shell); err != nil {
+			return err
+		}
+	}
+	for _, tag := range c.Tags {
+		if err := checkTag(tag); err != nil {
+			return err
+		}
+	}
+	if c.Group != "" {
+		return checkGroup(c.Group)
+	}
+	return nil
+}
+
+// decodeStructured parses data with unmarshal. An empty file holds no
+// commands.
+func decodeStructured(file string, data []byte, unmarshal func([]byte, any) error) ([]Command, []Diagnostic) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+	var list commandList
+	if err := unmarshal(data, &list); err != nil {
+		return nil, []Diagnostic{{File: file, Reason: err.Error() + "; file skipped"}}
+	}
+	return decodeList(file, list)
+}
+
+// yamlLoader reads and writes YAML command files, such as .commands.aqc.yaml.
+type yamlLoader struct{}
+
+func (yamlLoader) Decode(file string, data []byte) ([]Command, []Diagnostic) {
+	return decodeStructured(file, data, yaml.Unmarshal)
+}
+
+func (yamlLoader) Encode(commands []Command) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(newCommandList(commands)); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// tomlLoader reads and writes TOML command files, such as .commands.aqc.toml.
+type tomlLoader struct{}
+
+func (tomlLoader) Decode(file string, data []byte) ([]Command, []Diagnostic) {
+	return decodeStructured(file, data, toml.Unmarshal)
+}
+
+func (tomlLoader) Encode(commands []Command) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := toml.NewEncoder(&buf)
+	enc.Indent = ""
+	if err := enc.Encode(newCommandList(commands)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// jsonLoader reads and writes JSON command files, such as .commands.aqc.json.
+type jsonLoader struct{}
+
+func (jsonLoader) Decode(file string, data []byte) ([]Command, []Diagnostic) {
+	return decodeStructured(file, data, json.Unmarshal)
+}
+
+func (jsonLoader) Encode(commands []Command) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	// Commands are full of & and <, which would otherwise be escaped.
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(newCommandList(commands)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
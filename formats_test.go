@@ -0,0 +1,227 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/quick"
+	"unicode/utf8"
+)
+
+func TestFormatForPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{".commands.aqc", "aqc"},
+		{"/home/me/.config/aqc/commands.aqc", "aqc"},
+		{"web/.commands.aqc.yaml", "yaml"},
+		{".commands.aqc.toml", "toml"},
+		{".commands.aqc.json", "json"},
+		{".commands.aqc.yml", "yaml"},
+		{".aqc.toml", "toml"},
+		{"web/.aqc.json", "json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := formatForPath(tt.path).name; got != tt.expected {
+				t.Errorf("formatForPath(%q) = %q, expected %q", tt.path, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestConvertedPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		to       string
+		expected string
+	}{
+		{"web/.commands.aqc", "yaml", "web/.commands.aqc.yaml"},
+		{".commands.aqc.yml", "json", ".commands.aqc.json"},
+		{"web/.aqc.toml", "aqc", "web/.commands.aqc"},
+		{".aqc.json", "yaml", ".commands.aqc.yaml"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			to, _ := formatNamed(tt.to)
+			if got := convertedPath(tt.path, to); got != filepath.FromSlash(tt.expected) {
+				t.Errorf("convertedPath(%q, %s) = %q, expected %q", tt.path, tt.to, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestStructuredLoadersDecode(t *testing.T) {
+	tests := []struct {
+		format string
+		data   string
+	}{
+		{"yaml", "commands:\n  - name: Build\n    description: Build it\n    cmd: make\n  - name: Deploy\n    cmd: |\n      set -e\n      make deploy\n"},
+		{"toml", "[[commands]]\nname = \"Build\"\ndescription = \"Build it\"\ncmd = \"make\"\n\n[[commands]]\nname = \"Deploy\"\ncmd = \"\"\"\nset -e\nmake deploy\n\"\"\"\n"},
+		{"json", `{"commands": [{"name": "Build", "description": "Build it", "cmd": "make"}, {"name": "Deploy", "cmd": "set -e\nmake deploy\n"}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			f, err := formatNamed(tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			commands, diags := f.loader.Decode("f", []byte(tt.data))
+			if len(diags) != 0 {
+				t.Fatalf("Unexpected diagnostics: %v", diags)
+			}
+			if len(commands) != 2 {
+				t.Fatalf("Expected 2 commands, got %d", len(commands))
+			}
+			if c := commands[0]; c.Name != "Build" || c.Description != "Build it" || c.Cmd != "make" || c.Line != 1 {
+				t.Errorf("commands[0] = %+v", c)
+			}
+			if c := commands[1]; c.Name != "Deploy" || c.Cmd != "set -e\nmake deploy\n" || c.Line != 2 {
+				t.Errorf("commands[1] = %+v", c)
+			}
+		})
+	}
+}
+
+func TestStructuredLoadersDiagnostics(t *testing.T) {
+	commands, diags := yamlLoader{}.Decode("f.yaml", []byte("commands:\n  - name: No command\n  - name: Ok\n    cmd: ls\n"))
+	if len(commands) != 1 || len(diags) != 1 {
+		t.Errorf("Decode() = %d commands, %v; expected 1 command and 1 diagnostic", len(commands), diags)
+	}
+	if _, diags := (jsonLoader{}).Decode("f.json", []byte("{not json")); len(diags) != 1 {
+		t.Errorf("Decode() of broken JSON gave diagnostics %v, expected one", diags)
+	}
+	if commands, diags := (tomlLoader{}).Decode("f.toml", []byte("\n")); commands != nil || diags != nil {
+		t.Errorf("Decode() of an empty file = %v, %v; expected nothing", commands, diags)
+	}
+}
+
+func TestLoadersRoundTripProperty(t *testing.T) {
+	for _, f := range fileFormats {
+		t.Run(f.name, func(t *testing.T) {
+			roundTrips := func(c trickyCommand) bool {
+				want := Command(c)
+				if want.Name == "" || want.Cmd == "" {
+					return true
+				}
+				// The structured formats only hold valid UTF-8 text.
+				if f.name != "aqc" && !(utf8.ValidString(want.Name) && utf8.ValidString(want.Description) && utf8.ValidString(want.Cmd)) {
+					return true
+				}
+				data, err := f.loader.Encode([]Command{want, want})
+				if err != nil {
+					t.Logf("Encode(%+v) error = %v", want, err)
+					return false
+				}
+				got, diags := f.loader.Decode("f", data)
+				if len(diags) != 0 || !sameCommands(got, []Command{want, want}) {
+					t.Logf("%q read back as %+v (%v)", data, got, diags)
+					return false
+				}
+				return true
+			}
+			if err := quick.Check(roundTrips, &quick.Config{MaxCount: 2000}); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestConvertFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, commandsFile)
+	content := "# comments are not carried over\nls -la\n- List: List files\n---\n```\nset -e\nmake\n```\n- \"Build: all\": Build everything\n---\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	original, _ := ParseCommandFile(path, content)
+
+	for _, name := range []string{"yaml", "toml", "json"} {
+		t.Run(name, func(t *testing.T) {
+			to, _ := formatNamed(name)
+			data, count, err := ConvertFile(path, to)
+			if err != nil {
+				t.Fatalf("ConvertFile() error = %v", err)
+			}
+			if count != 2 {
+				t.Errorf("count = %d, expected 2", count)
+			}
+			out := path + to.ext
+			if err := os.WriteFile(out, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			// And back again.
+			back, _, err := ConvertFile(out, fileFormats[0])
+			if err != nil {
+				t.Fatalf("ConvertFile() back error = %v", err)
+			}
+			if !strings.HasPrefix(string(back), formatHeader(formatVersion)+"\n") {
+				t.Errorf("Converted block file has no header:\n%s", back)
+			}
+			commands, _ := ParseCommandFile(path, string(back))
+			if !sameCommands(commands, original) {
+				t.Errorf("Commands after converting back = %+v, expected %+v", commands, original)
+			}
+		})
+	}
+}
+
+func TestStructuredFileEdits(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, commandsFile+".json")
+
+	if err := AppendCommandTo(path, Command{Cmd: "ls", Name: "List"}); err != nil {
+		t.Fatalf("AppendCommandTo() error = %v", err)
+	}
+	if err := AppendCommandTo(path, Command{Cmd: "pwd", Name: "Dir"}); err != nil {
+		t.Fatalf("AppendCommandTo() error = %v", err)
+	}
+	commands, err := readEntries(path)
+	if err != nil || len(commands) != 2 {
+		t.Fatalf("readEntries() = %v, %v; expected 2 commands", commands, err)
+	}
+
+	updated := commands[0]
+	updated.Description = "List files"
+	if err := UpdateCommand(commands[0], updated); err != nil {
+		t.Fatalf("UpdateCommand() error = %v", err)
+	}
+	if err := RemoveCommand(commands[1]); err != nil {
+		t.Fatalf("RemoveCommand() error = %v", err)
+	}
+	commands, _ = readEntries(path)
+	if len(commands) != 1 || commands[0].Description != "List files" {
+		t.Errorf("Commands after edits = %+v", commands)
+	}
+}
+
+func TestLoadersKeepEnvAndDefaults(t *testing.T) {
+	defaults := &Defaults{Env: []string{"A=1"}, EnvFiles: []string{".env"}, Shell: "zsh"}
+	confirm := true
+	commands := []Command{
+		{Cmd: "npm start", Name: "Start", Env: []string{"PORT=3000", "URL=http://localhost:${PORT}"}, Needs: []string{"Build", "Lint"}, Defaults: defaults},
+		{Cmd: "make", Name: "Build", EnvFiles: []string{"build.env"}, Shell: "bash -eu -c", Defaults: defaults},
+		{Cmd: "kubectl apply", Name: "Deploy", Group: "deploy", Tags: []string{"prod", "k8s"}, Confirm: &confirm, Defaults: defaults},
+	}
+	for _, f := range fileFormats {
+		t.Run(f.name, func(t *testing.T) {
+			data, err := f.loader.Encode(commands)
+			if err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			got, diags := f.loader.Decode("f", data)
+			if len(diags) != 0 || !sameCommands(got, commands) {
+				t.Errorf("%s read back as %+v (%v)", data, got, diags)
+			}
+		})
+	}
+
+	if _, diags := (jsonLoader{}).Decode("f.json", []byte(`{"commands": [{"name": "A", "cmd": "a", "env": ["no value"]}]}`)); len(diags) != 1 {
+		t.Errorf("A bad env entry gave diagnostics %v, expected one", diags)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Scores used by fuzzyMatch. Matches at the start of a word and runs of
+// consecutive characters are worth more; gaps between matches cost a little.
+const (
+	fuzzyMatchScore       = 16
+	fuzzyWordStartBonus   = 8
+	fuzzyConsecutiveBonus = 12
+	fuzzyGapPenalty       = 1
+)
+
+// fuzzyMatch reports whether every rune of pattern appears in text in order,
+// ignoring case. It returns the best score found and the rune positions in
+// text that matched.
+func fuzzyMatch(pattern, text string) (int, []int, bool) {
+	p := []rune(pattern)
+	for i, r := range p {
+		p[i] = unicode.ToLower(r)
+	}
+	t := []rune(text)
+	lower := make([]rune, len(t))
+	for i, r := range t {
+		lower[i] = unicode.ToLower(r)
+	}
+	if len(p) == 0 {
+		return 0, nil, true
+	}
+	if len(p) > len(t) {
+		return 0, nil, false
+	}
+
+	// best[i][j] is the best score for matching p[:i+1] with p[i] at t[j];
+	// from[i][j] is where p[i-1] matched on that path.
+	const none = -1 << 30
+	best := make([][]int, len(p))
+	from := make([][]int, len(p))
+	for i := range p {
+		best[i] = make([]int, len(t))
+		from[i] = make([]int, len(t))
+		for j := range t {
+			best[i][j] = none
+			if lower[j] != p[i] {
+				continue
+			}
+			bonus := fuzzyMatchScore
+			if j == 0 || isWordBoundary(t[j-1], t[j]) {
+				bonus += fuzzyWordStartBonus
+			}
+			if i == 0 {
+				best[i][j] = bonus - j*fuzzyGapPenalty/4
+				continue
+			}
+			for k := i - 1; k < j; k++ {
+				if best[i-1][k] == none {
+					continue
+				}
+				score := best[i-1][k] + bonus
+				if k == j-1 {
+					score += fuzzyConsecutiveBonus
+				} else {
+					score -= (j - k - 1) * fuzzyGapPenalty
+				}
+				if score > best[i][j] {
+					best[i][j] = score
+					from[i][j] = k
+				}
+			}
+		}
+	}
+
+	last := len(p) - 1
+	end := -1
+	for j := range t {
+		if best[last][j] != none && (end == -1 || best[last][j] > best[last][end]) {
+			end = j
+		}
+	}
+	if end == -1 {
+		return 0, nil, false
+	}
+	positions := make([]int, len(p))
+	for i, j := last, end; i >= 0; i-- {
+		positions[i] = j
+		j = from[i][j]
+	}
+	return best[last][end], positions, true
+}
+
+// isWordBoundary reports whether cur starts a new word after prev.
+func isWordBoundary(prev, cur rune) bool {
+	if !unicode.IsLetter(prev) && !unicode.IsDigit(prev) {
+		return true
+	}
+	return unicode.IsLower(prev) && unicode.IsUpper(cur)
+}
+
+// Fields a fuzzy match can be found in.
+const (
+	matchName = iota
+	matchDescription
+	matchCmd
+)
+
+// commandMatch is a command that matched a search, with the field and rune
+// positions to highlight.
+type commandMatch struct {
+	index     int
+	score     int
+	field     int
+	positions []int
+}
+
+// fuzzyFilter matches pattern against the name, description and command of
+// each command and returns the matches, best first. Name matches rank above
+// description matches, which rank above command matches. Ties keep the file
+// order.
+func fuzzyFilter(commands []Command, pattern string) []commandMatch {
+	var matches []commandMatch
+	for i, c := range commands {
+		var found *commandMatch
+		fields := []string{c.Name, c.Description, c.Cmd}
+		weights := []int{3, 2, 1}
+		for field, text := range fields {
+			score, positions, ok := fuzzyMatch(pattern, text)
+			if !ok {
+				continue
+			}
+			score *= weights[field]
+			if found == nil || score > found.score {
+				found = &commandMatch{index: i, score: score, field: field, positions: positions}
+			}
+		}
+		if found != nil {
+			matches = append(matches, *found)
+		}
+	}
+	sort.SliceStable(matches, func(a, b int) bool {
+		return matches[a].score > matches[b].score
+	})
+	return matches
+}
+
+// highlightRunes colors the runes of s at the given positions with color,
+// switching back to base after each of them.
+func highlightRunes(s string, positions []int, color, base string) string {
+	if len(positions) == 0 {
+		return s
+	}
+	marked := make(map[int]bool, len(positions))
+	for _, p := range positions {
+		marked[p] = true
+	}
+	var b strings.Builder
+	for i, r := range []rune(s) {
+		if marked[i] {
+			b.WriteString(color + string(r) + ColorReset + base)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFuzzyMatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		pattern   string
+		text      string
+		ok        bool
+		positions []int
+	}{
+		{"empty pattern", "", "anything", true, nil},
+		{"exact", "build", "build", true, []int{0, 1, 2, 3, 4}},
+		{"case-insensitive", "DB", "docker build", true, []int{0, 7}},
+		{"prefers word starts", "dp", "deploy prod", true, []int{0, 7}},
+		{"prefers consecutive runs", "test", "the best test", true, []int{9, 10, 11, 12}},
+		{"out of order", "ba", "abc", false, nil},
+		{"longer than text", "abcd", "abc", false, nil},
+		{"unicode", "éc", "école", true, []int{0, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, positions, ok := fuzzyMatch(tt.pattern, tt.text)
+			if ok != tt.ok {
+				t.Fatalf("fuzzyMatch(%q, %q) ok = %v, expected %v", tt.pattern, tt.text, ok, tt.ok)
+			}
+			if ok && !reflect.DeepEqual(positions, tt.positions) {
+				t.Errorf("fuzzyMatch(%q, %q) positions = %v, expected %v", tt.pattern, tt.text, positions, tt.positions)
+			}
+		})
+	}
+}
+
+func TestFuzzyMatchScoresTighterMatchesHigher(t *testing.T) {
+	tight, _, _ := fuzzyMatch("dep", "deploy")
+	loose, _, _ := fuzzyMatch("dep", "docker exec postgres")
+	if tight <= loose {
+		t.Errorf("score(deploy) = %d should be higher than score(docker exec postgres) = %d", tight, loose)
+	}
+}
+
+func TestFuzzyFilter(t *testing.T) {
+	commands := []Command{
+		{Name: "List Files", Description: "ls with details", Cmd: "ls -la"},
+		{Name: "Docker Build", Description: "Build the image", Cmd: "docker build ."},
+		{Name: "Test", Description: "Run unit tests", Cmd: "go test ./..."},
+		{Name: "Deploy", Description: "Ship to production", Cmd: "kubectl apply -f k8s/"},
+	}
+
+	tests := []struct {
+		name          string
+		pattern       string
+		expectedFirst int
+		expectedField int
+		count         int
+	}{
+		{"name match", "dock", 1, matchName, 1},
+		{"description match", "production", 3, matchDescription, 1},
+		{"command match", "kubectl", 3, matchCmd, 1},
+		{"no match", "zzz", -1, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches := fuzzyFilter(commands, tt.pattern)
+			if len(matches) != tt.count {
+				t.Fatalf("fuzzyFilter(%q) returned %d matches, expected %d: %+v", tt.pattern, len(matches), tt.count, matches)
+			}
+			if tt.count == 0 {
+				return
+			}
+			if matches[0].index != tt.expectedFirst || matches[0].field != tt.expectedField {
+				t.Errorf("First match = %+v, expected index %d in field %d", matches[0], tt.expectedFirst, tt.expectedField)
+			}
+		})
+	}
+}
+
+func TestHighlightRunes(t *testing.T) {
+	result := highlightRunes("abc", []int{1}, ColorYellow, ColorGreen)
+	expected := "a" + ColorYellow + "b" + ColorReset + ColorGreen + "c"
+	if result != expected {
+		t.Errorf("highlightRunes() = %q, expected %q", result, expected)
+	}
+	if result := highlightRunes("abc", nil, ColorYellow, ""); result != "abc" {
+		t.Errorf("highlightRunes() without positions = %q, expected %q", result, "abc")
+	}
+}
@@ -2,6 +2,10 @@ module github.com/amantham20/AQC
 
 go 1.24.1
 
-require golang.org/x/term v0.30.0
+require (
+	github.com/BurntSushi/toml v1.6.0
+	golang.org/x/term v0.30.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require golang.org/x/sys v0.31.0 // indirect
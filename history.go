@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// historyEntry is a run of a command as recorded in the run history. Cwd is
+// where AQC was started and Dir where the command ran; Cmd is the command text
+// with its placeholders filled in. Source is the absolute path of the file the
+// command came from.
+type historyEntry struct {
+	Time       time.Time `json:"time"`
+	Cwd        string    `json:"cwd"`
+	Dir        string    `json:"dir"`
+	Name       string    `json:"name"`
+	Cmd        string    `json:"cmd"`
+	Source     string    `json:"source,omitempty"`
+	ExitCode   int       `json:"exit_code"`
+	DurationMs int64     `json:"duration_ms"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Success reports whether the recorded run exited with status 0.
+func (e historyEntry) Success() bool {
+	return e.ExitCode == 0 && e.Error == ""
+}
+
+// maxHistory is the number of runs the history keeps. Older runs are dropped
+// when the file grows past maxHistorySize bytes.
+const (
+	maxHistory     = 1000
+	maxHistorySize = 1 << 20
+)
+
+// historyMu keeps runs that end at the same time from interleaving their
+// lines.
+var historyMu sync.Mutex
+
+// historyFile returns the path of the run history, one JSON object per line,
+// oldest first.
+func historyFile() string {
+	return filepath.Join(dataDir(), "history.jsonl")
+}
+
+// recordRun adds a run of c to the history. Failing to write it does not
+// affect the run, so the error is only logged.
+func recordRun(c Command, result RunResult) {
+	cwd, _ := os.Getwd()
+	dir := workDir(c)
+	if dir == "" {
+		dir = cwd
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	source := c.Source
+	if source != "" {
+		source = absSource(source, cwd)
+	}
+	entry := historyEntry{
+		Time:       time.Now().Add(-result.Duration),
+		Cwd:        cwd,
+		Dir:        dir,
+		Name:       c.Name,
+		Cmd:        c.Cmd,
+		Source:     source,
+		ExitCode:   result.ExitCode,
+		DurationMs: result.Duration.Milliseconds(),
+	}
+	if result.Err != nil {
+		entry.Error = result.Err.Error()
+	}
+	if err := appendHistory(historyFile(), entry); err != nil && debugFile != nil {
+		fmt.Fprintf(debugFile, "Error saving run history: %v\n", err)
+	}
+}
+
+// appendHistory adds entry to the history file at path, dropping the oldest
+// runs once the file gets too big.
+func appendHistory(path string, entry historyEntry) error {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if info, statErr := os.Stat(path); err == nil && statErr == nil && info.Size() > maxHistorySize {
+		err = trimHistory(path)
+	}
+	return err
+}
+
+// trimHistory keeps the last maxHistory runs of the history file at path.
+func trimHistory(path string) error {
+	entries, err := loadHistoryEntries(path)
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	for _, e := range entries[max(len(entries)-maxHistory, 0):] {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		b.Write(append(line, '\n'))
+	}
+	return writeFileAtomic(path, b.Bytes())
+}
+
+// loadHistoryEntries reads the history file at path, oldest run first. A
+// missing file is an empty history; lines that cannot be read are skipped.
+func loadHistoryEntries(path string) ([]historyEntry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []historyEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, maxHistorySize)
+	for scanner.Scan() {
+		var e historyEntry
+		if json.Unmarshal(scanner.Bytes(), &e) == nil {
+			entries = append(entries, e)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// recentRuns returns the runs in the history newest first, so that run N is
+// recent[N-1].
+func recentRuns() ([]historyEntry, error) {
+	entries, err := loadHistoryEntries(historyFile())
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, err
+}
+
+// absSource returns path as an absolute path, taking a relative one to be
+// relative to dir.
+func absSource(path, dir string) string {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	return filepath.Clean(path)
+}
+
+// numberedRun is a run with its number for "aqc rerun".
+type numberedRun struct {
+	Index int `json:"index"`
+	historyEntry
+}
+
+// selectRuns numbers the runs, newest first, and keeps the failed ones if
+// failed is set, at most limit of them; 0 means no limit.
+func selectRuns(recent []historyEntry, failed bool, limit int) []numberedRun {
+	var runs []numberedRun
+	for i, e := range recent {
+		if limit > 0 && len(runs) == limit {
+			break
+		}
+		if !failed || !e.Success() {
+			runs = append(runs, numberedRun{Index: i + 1, historyEntry: e})
+		}
+	}
+	return runs
+}
+
+// formatRun renders a run on one line: its number, when it started, how it
+// ended, how long it took, and the command. The command is shortened so the
+// line fits in width runes; 0 means no limit.
+func formatRun(r numberedRun, width int, color bool) string {
+	status := "ok"
+	statusColor := ColorGreen
+	if !r.Success() {
+		status = "exit " + strconv.Itoa(r.ExitCode)
+		statusColor = ColorRed
+	}
+	duration := formatDuration(time.Duration(r.DurationMs) * time.Millisecond)
+	started := r.Time.Local().Format("2006-01-02 15:04")
+	head := fmt.Sprintf("%3d  %s  %-7s  %7s  ", r.Index, started, status, duration)
+	rest := r.Name + ": " + oneLine(r.Cmd)
+	if width > 0 {
+		rest = truncate(rest, width-utf8.RuneCountInString(head))
+	}
+	if !color {
+		return head + rest
+	}
+	head = fmt.Sprintf("%s%3d%s  %s  %s%-7s%s  %7s  ", ColorCyan, r.Index, ColorReset, started, statusColor, status, ColorReset, duration)
+	if strings.HasPrefix(rest, r.Name) {
+		rest = ColorGreen + r.Name + ColorReset + rest[len(r.Name):]
+	}
+	return head + rest
+}
+
+// writeRuns prints the runs, one per line, or as JSON.
+func writeRuns(w io.Writer, runs []numberedRun, asJSON bool, width int, color bool) error {
+	if asJSON {
+		if runs == nil {
+			runs = []numberedRun{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(runs)
+	}
+	for _, r := range runs {
+		fmt.Fprintln(w, formatRun(r, width, color))
+	}
+	return nil
+}
+
+// HistorySubcommand handles the "history" subcommand to print the commands
+// that were run, newest first.
+func HistorySubcommand() {
+	historyCmd := flag.NewFlagSet("history", flag.ExitOnError)
+	failedPtr := historyCmd.Bool("failed", false, "Only show runs that failed")
+	jsonPtr := historyCmd.Bool("json", false, "Print the runs as JSON")
+	limitPtr := historyCmd.Int("limit", 20, "Show at most this many runs, or 0 for all")
+	historyCmd.Parse(os.Args[2:])
+
+	recent, err := recentRuns()
+	if err != nil {
+		fmt.Printf("%sError reading history: %v%s\n", ColorRed, err, ColorReset)
+		os.Exit(1)
+	}
+	runs := selectRuns(recent, *failedPtr, *limitPtr)
+	isTerminal := term.IsTerminal(int(os.Stdout.Fd()))
+	width := 0
+	if isTerminal {
+		width = getTerminalWidth()
+	}
+	if err := writeRuns(os.Stdout, runs, *jsonPtr, width, isTerminal); err != nil {
+		fmt.Printf("%sError: %v%s\n", ColorRed, err, ColorReset)
+		os.Exit(1)
+	}
+	if len(runs) == 0 && !*jsonPtr && isTerminal {
+		fmt.Println(ColorYellow + "No runs to show." + ColorReset)
+	}
+}
+
+// RerunSubcommand handles the "rerun" subcommand to run an entry of the
+// history again: the last run, or run N as numbered by "aqc history". Only the
+// recorded command runs; the commands it needs have runs of their own.
+func RerunSubcommand() {
+	rerunCmd := flag.NewFlagSet("rerun", flag.ExitOnError)
+	rerunCmd.BoolVar(&assumeYes, "yes", false, "Run commands that need confirmation without asking")
+	rerunCmd.Usage = func() {
+		fmt.Fprintln(rerunCmd.Output(), "Usage: aqc rerun [--yes] [N]")
+		rerunCmd.PrintDefaults()
+	}
+	// The run number is optional and flags may follow it.
+	rerunCmd.Parse(os.Args[2:])
+	query := rerunCmd.Arg(0)
+	if query != "" {
+		rerunCmd.Parse(rerunCmd.Args()[1:])
+	}
+
+	n := 1
+	if query != "" {
+		var err error
+		if n, err = strconv.Atoi(query); err != nil || n < 1 {
+			fmt.Fprintf(os.Stderr, "%sError: %q is not a run number; see aqc history%s\n", ColorRed, query, ColorReset)
+			os.Exit(1)
+		}
+	}
+	recent, err := recentRuns()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sError reading history: %v%s\n", ColorRed, err, ColorReset)
+		os.Exit(1)
+	}
+	if n > len(recent) {
+		fmt.Fprintf(os.Stderr, "%sError: no run number %d (there are %d)%s\n", ColorRed, n, len(recent), ColorReset)
+		os.Exit(1)
+	}
+
+	c := rerunCommand(recent[n-1])
+	if !confirmRun([]Command{c}) {
+		os.Exit(1)
+	}
+	fmt.Fprintln(os.Stderr, ColorCyan+"Running again:"+ColorReset+" "+c.Cmd)
+	result := RunCommand(c)
+	if result.Err != nil {
+		fmt.Fprintf(os.Stderr, "%sError executing command: %v%s\n", ColorRed, result.Err, ColorReset)
+	}
+	os.Exit(result.ExitCode)
+}
+
+// rerunCommand returns the command that runs e again: the saved command it
+// came from, read from its own file so its shell and environment apply from
+// any directory, or a plain command when the file or the command is gone.
+// Either way it runs e's command text in e's directory.
+func rerunCommand(e historyEntry) Command {
+	c := Command{Name: e.Name}
+	if e.Source != "" {
+		c = savedCommand(absSource(e.Source, e.Cwd), e.Name, c)
+	}
+	c.Cmd = e.Cmd
+	c.Dir = e.Dir
+	return c
+}
+
+// savedCommand returns the command called name in the file at path, or
+// fallback when there is none.
+func savedCommand(path, name string, fallback Command) Command {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fallback
+	}
+	commands, _ := formatForPath(path).loader.Decode(relPath(path), data)
+	for _, c := range commands {
+		if c.Name == name {
+			c.Global = path == globalCommandsFile()
+			return c
+		}
+	}
+	return fallback
+}
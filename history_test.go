@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMain keeps the runs made by the tests out of the user's history.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "aqc-test")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("XDG_DATA_HOME", dir)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestHistoryFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "aqc", "history.jsonl")
+	if entries, err := loadHistoryEntries(path); err != nil || entries != nil {
+		t.Fatalf("loadHistoryEntries() of a missing file = %v, %v", entries, err)
+	}
+
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, name := range []string{"Build", "Test", "Deploy"} {
+		e := historyEntry{Time: start.Add(time.Duration(i) * time.Minute), Name: name, Cmd: strings.ToLower(name), ExitCode: i}
+		if err := appendHistory(path, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A broken line is skipped.
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString("{not json\n")
+	f.Close()
+
+	entries, err := loadHistoryEntries(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[0].Name != "Build" || entries[2].Name != "Deploy" || entries[2].ExitCode != 2 {
+		t.Fatalf("loadHistoryEntries() = %+v", entries)
+	}
+	if !entries[2].Time.Equal(start.Add(2 * time.Minute)) {
+		t.Errorf("Time = %v, expected %v", entries[2].Time, start.Add(2*time.Minute))
+	}
+}
+
+func TestTrimHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	var b bytes.Buffer
+	for i := 0; i < maxHistory+10; i++ {
+		line, _ := json.Marshal(historyEntry{Name: "run", ExitCode: i})
+		b.Write(append(line, '\n'))
+	}
+	if err := os.WriteFile(path, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := trimHistory(path); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := loadHistoryEntries(path)
+	if len(entries) != maxHistory || entries[0].ExitCode != 10 || entries[len(entries)-1].ExitCode != maxHistory+9 {
+		t.Errorf("trimHistory() kept %d runs, from %d", len(entries), entries[0].ExitCode)
+	}
+}
+
+func TestSelectRuns(t *testing.T) {
+	recent := []historyEntry{
+		{Name: "a"},
+		{Name: "b", ExitCode: 1},
+		{Name: "c"},
+		{Name: "d", ExitCode: -1, Error: "not found"},
+		{Name: "e", ExitCode: 2},
+	}
+	tests := []struct {
+		name    string
+		failed  bool
+		limit   int
+		indexes []int
+	}{
+		{"all", false, 0, []int{1, 2, 3, 4, 5}},
+		{"limit", false, 2, []int{1, 2}},
+		{"failed", true, 0, []int{2, 4, 5}},
+		{"failed with limit", true, 2, []int{2, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var indexes []int
+			for _, r := range selectRuns(recent, tt.failed, tt.limit) {
+				indexes = append(indexes, r.Index)
+				if recent[r.Index-1].Name != r.Name {
+					t.Errorf("Run %d is %s, expected %s", r.Index, r.Name, recent[r.Index-1].Name)
+				}
+			}
+			if !reflect.DeepEqual(indexes, tt.indexes) {
+				t.Errorf("selectRuns() = %v, expected %v", indexes, tt.indexes)
+			}
+		})
+	}
+}
+
+func TestFormatRun(t *testing.T) {
+	at := time.Date(2025, 3, 1, 12, 30, 0, 0, time.Local)
+	tests := []struct {
+		name     string
+		run      numberedRun
+		width    int
+		expected string
+	}{
+		{
+			"success",
+			numberedRun{1, historyEntry{Time: at, Name: "Build", Cmd: "make build", DurationMs: 1500}},
+			0,
+			"  1  2025-03-01 12:30  ok          1.5s  Build: make build",
+		},
+		{
+			"failure",
+			numberedRun{12, historyEntry{Time: at, Name: "Test", Cmd: "go test\n./...", ExitCode: 1, DurationMs: 200}},
+			0,
+			" 12  2025-03-01 12:30  exit 1     200ms  Test: go test ↵ ./...",
+		},
+		{
+			"shortened",
+			numberedRun{1, historyEntry{Time: at, Name: "Build", Cmd: "make build", DurationMs: 1500}},
+			48,
+			"  1  2025-03-01 12:30  ok          1.5s  Build:…",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatRun(tt.run, tt.width, false); got != tt.expected {
+				t.Errorf("formatRun() = %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+
+	colored := formatRun(tests[0].run, 0, true)
+	if !strings.Contains(colored, ColorGreen+"Build"+ColorReset+": make build") {
+		t.Errorf("formatRun() with color = %q", colored)
+	}
+}
+
+func TestWriteRunsJSON(t *testing.T) {
+	var b bytes.Buffer
+	if err := writeRuns(&b, nil, true, 0, false); err != nil || strings.TrimSpace(b.String()) != "[]" {
+		t.Errorf("writeRuns() of no runs = %q, %v", b.String(), err)
+	}
+
+	b.Reset()
+	runs := []numberedRun{{2, historyEntry{Name: "Test", Cmd: "go test", ExitCode: 1, DurationMs: 200}}}
+	if err := writeRuns(&b, runs, true, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []map[string]any
+	if err := json.Unmarshal(b.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 1 || decoded[0]["index"] != 2.0 || decoded[0]["name"] != "Test" || decoded[0]["exit_code"] != 1.0 || decoded[0]["duration_ms"] != 200.0 {
+		t.Errorf("writeRuns() = %s", b.String())
+	}
+}
+
+func TestRerunCommand(t *testing.T) {
+	project := t.TempDir()
+	source := filepath.Join(project, commandsFile+".yaml")
+	content := "commands:\n  - name: Build\n    cmd: make {{target}}\n    shell: bash\n    env: [FOO=bar]\n"
+	if err := os.WriteFile(source, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Another directory, with a commands file of its own that has a Build too.
+	other := t.TempDir()
+	if err := os.WriteFile(filepath.Join(other, commandsFile), []byte("npm run build\n- Build\n---\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+
+	tests := []struct {
+		name  string
+		dir   string
+		entry historyEntry
+		shell string
+	}{
+		{"same directory", project, historyEntry{Name: "Build", Cmd: "make release", Dir: project, Cwd: project, Source: source}, "bash"},
+		{"no commands file", t.TempDir(), historyEntry{Name: "Build", Cmd: "make release", Dir: project, Cwd: project, Source: source}, "bash"},
+		{"other commands file", other, historyEntry{Name: "Build", Cmd: "make release", Dir: project, Cwd: project, Source: source}, "bash"},
+		// Older entries hold a source relative to where they were recorded.
+		{"relative source", other, historyEntry{Name: "Build", Cmd: "make release", Dir: project, Cwd: filepath.Dir(project), Source: filepath.Join(filepath.Base(project), filepath.Base(source))}, "bash"},
+		// A command that is gone runs as it was recorded.
+		{"removed command", other, historyEntry{Name: "Old", Cmd: "make release", Dir: project, Cwd: project, Source: source}, ""},
+		{"removed file", other, historyEntry{Name: "Build", Cmd: "make release", Dir: project, Cwd: project, Source: filepath.Join(project, "gone.yaml")}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.Chdir(tt.dir); err != nil {
+				t.Fatal(err)
+			}
+			c := rerunCommand(tt.entry)
+			if c.Name != tt.entry.Name || c.Cmd != "make release" || c.Dir != project || c.Shell != tt.shell {
+				t.Errorf("rerunCommand() = %+v", c)
+			}
+			if tt.shell != "" && (!reflect.DeepEqual(c.Env, []string{"FOO=bar"}) || absSource(c.Source, tt.dir) != source) {
+				t.Errorf("rerunCommand() = %+v, expected the saved command", c)
+			}
+			if tt.shell == "" && c.Source != "" {
+				t.Errorf("rerunCommand() of a removed command has source %q", c.Source)
+			}
+		})
+	}
+}
+
+func TestRerunFromOtherDirectory(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	project := t.TempDir()
+	source := filepath.Join(project, commandsFile)
+	if err := os.WriteFile(source, []byte(formatHeader(formatVersion)+"\necho \"$FOO\" > out\n- Save\nenv: FOO=bar\n---\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(source)
+	commands, _ := ParseCommandFile(source, string(data))
+	RunCommand(commands[0])
+	os.Remove(filepath.Join(project, "out"))
+
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	recent, _ := recentRuns()
+	if len(recent) != 1 {
+		t.Fatalf("recentRuns() = %+v, expected one run", recent)
+	}
+	if result := RunCommand(rerunCommand(recent[0])); result.ExitCode != 0 {
+		t.Fatalf("Rerun exit code = %d (%v)", result.ExitCode, result.Err)
+	}
+	out, err := os.ReadFile(filepath.Join(project, "out"))
+	if err != nil || strings.TrimSpace(string(out)) != "bar" {
+		t.Errorf("Rerun wrote %q, %v; expected the saved environment", out, err)
+	}
+}
+
+func TestRunCommandRecordsHistory(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	dir := t.TempDir()
+	RunCommand(Command{Name: "Fail", Cmd: "exit 3", Source: filepath.Join(dir, commandsFile)})
+
+	recent, err := recentRuns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recent) != 1 {
+		t.Fatalf("recentRuns() = %+v, expected one run", recent)
+	}
+	e := recent[0]
+	if e.Name != "Fail" || e.Cmd != "exit 3" || e.ExitCode != 3 || e.Success() || e.Dir != dir {
+		t.Errorf("Recorded run = %+v", e)
+	}
+	if cwd, _ := os.Getwd(); e.Cwd != cwd {
+		t.Errorf("Cwd = %q, expected %q", e.Cwd, cwd)
+	}
+	if e.Source != filepath.Join(dir, commandsFile) {
+		t.Errorf("Source = %q, expected an absolute path", e.Source)
+	}
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"strings"
+	"sync"
 
 	"golang.org/x/term"
 )
@@ -10,12 +13,13 @@ import (
 // At the top of interactive.go, add a global variable for the debug log file.
 var debugFile *os.File
 
-func InteractiveModeWithDefault() {
-	InteractiveMode(-1)
+func InteractiveModeWithDefault() int {
+	return InteractiveMode(-1)
 }
 
 // In InteractiveMode, open the debug log file.
-func InteractiveMode(index int) {
+// It returns the exit status of the command that was run, or 0 if none was.
+func InteractiveMode(index int) int {
 
 	// Open (or create) the debug log file.
 	var err error
@@ -29,39 +33,141 @@ func InteractiveMode(index int) {
 		}
 	}()
 
-	commands := LoadCommands()
+	commands, diags := LoadCommands()
 	if len(commands) == 0 {
+		printDiagnostics(diags)
 		fmt.Println(ColorRed + "No commands found in the file." + ColorReset)
 		os.Exit(1)
 	}
 
+	// "aqc N --set name=value" fills placeholders of command N up front.
+	values := make(setFlags)
+	if index >= 0 {
+		numCmd := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+		numCmd.Var(values, "set", "Set a placeholder value as name=value (repeatable)")
+		numCmd.BoolVar(&assumeYes, "yes", false, "Run the command without asking when it needs confirmation")
+		numCmd.Parse(os.Args[2:])
+	}
+
+	// Run the numbered command directly, otherwise let the user pick one.
+	var restore func()
+	var choice menuChoice
+	if index >= 1 && index <= len(commands) {
+		choice.commands = []Command{commands[index-1]}
+	} else {
+		restore = startTUI()
+		// Display the menu with scrolling
+		var ok bool
+		choice, ok = displayScrollableMenu(commands, diagnosticsBanner(diags))
+		if !ok {
+			restore()
+			return 0
+		}
+	}
+
+	var chosen []Command
+	for _, c := range choice.commands {
+		if choice.replay {
+			chosen = append(chosen, c)
+			continue
+		}
+		var ok bool
+		if c, ok, err = fillValues(c, values, &restore); !ok {
+			restore()
+			return 0
+		}
+		if err != nil {
+			break
+		}
+		chosen = append(chosen, c)
+	}
+
+	var needs, steps []Command
+	switch {
+	case err != nil:
+	case choice.replay:
+		steps = chosen
+	case choice.parallel:
+		if needs, err = parallelNeeds(commands, chosen, values); err == nil {
+			steps = append(append(steps, needs...), chosen...)
+		}
+	default:
+		steps, err = sequenceSteps(commands, chosen, values)
+	}
+	// Dangerous commands are confirmed before anything runs.
+	confirmedRun := true
+	if err == nil {
+		confirmedRun, err = confirmSteps(steps, &restore)
+	}
+
+	// Restore terminal and exit alternate screen before running
+	if restore != nil {
+		restore()
+	}
+	if err != nil {
+		fmt.Printf("%sError: %v%s\n", ColorRed, err, ColorReset)
+		return 1
+	}
+	if !confirmedRun {
+		return 0
+	}
+	if choice.parallel {
+		return runParallelSteps(needs, chosen)
+	}
+
+	if len(steps) == 1 {
+		fmt.Println(ColorCyan + "Executing:" + ColorReset + " " + steps[0].Cmd + "\n")
+	}
+	result := runSteps(steps, choice.keepGoing)
+	if !result.Success() {
+		fmt.Printf("%sError executing command: %s%s\n", ColorRed, result, ColorReset)
+	}
+	return result.ExitCode
+}
+
+// fillValues fills the placeholders of c from values and asks for the
+// missing ones, starting the terminal UI if restore is nil. ok is false when
+// the user cancelled.
+func fillValues(c Command, values setFlags, restore *func()) (filled Command, ok bool, err error) {
+	filled, missing, err := applyValues(c, values, false)
+	if err != nil || len(missing) == 0 {
+		return filled, true, err
+	}
+	if *restore == nil {
+		*restore = startTUI()
+	}
+	history := loadValueHistory()
+	if !promptPlaceholders(filled, missing, values, history) {
+		return c, false, nil
+	}
+	if err := history.save(); err != nil && debugFile != nil {
+		fmt.Fprintf(debugFile, "Error saving value history: %v\n", err)
+	}
+	filled, _, err = applyValues(filled, values, false)
+	return filled, true, err
+}
+
+// startTUI enters the alternate screen and puts the terminal in raw mode so
+// individual keystrokes can be captured. It returns a function that restores
+// the terminal.
+func startTUI() func() {
 	// Enter alternate screen mode so the application takes over the terminal.
 	EnterAlternateScreen()
 
 	// Initialize terminal for raw mode to capture individual keystrokes
 	oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
 	if err != nil {
-		fmt.Fprintf(debugFile, "Error setting up terminal in raw mode: %v\n", err)
+		if debugFile != nil {
+			fmt.Fprintf(debugFile, "Error setting up terminal in raw mode: %v\n", err)
+		}
 		ExitAlternateScreen() // Exit alternate screen if there's an error
 		fmt.Printf("%sError setting up terminal: %v%s\n", ColorRed, err, ColorReset)
 		os.Exit(1)
 	}
-
-	// Display the menu with scrolling
-	selectedIndex := displayScrollableMenu(commands)
-
-	// Restore terminal and exit alternate screen before returning
-	term.Restore(int(os.Stdin.Fd()), oldState)
-	ExitAlternateScreen()
-
-	if selectedIndex < 0 || selectedIndex >= len(commands) {
-		return
+	return func() {
+		term.Restore(int(os.Stdin.Fd()), oldState)
+		ExitAlternateScreen()
 	}
-
-	selected := commands[selectedIndex]
-
-	fmt.Println(ColorCyan + "Executing:" + ColorReset + " " + selected.Cmd + "\n")
-	RunCommand(selected.Cmd)
 }
 
 func getTerminalHeight() int {
@@ -93,136 +199,831 @@ func getTerminalWidth() int {
 	return width
 }
 
+// menuChoice is what the user chose in the menu: the commands to run, and
+// whether to run them at the same time or, when running them one after the
+// other, to go on after one fails. replay is set for a run picked from the
+// history, which runs as recorded, like "aqc rerun" runs it: without asking
+// for placeholders or running the commands it needs.
+type menuChoice struct {
+	commands  []Command
+	parallel  bool
+	keepGoing bool
+	replay    bool
+}
+
 // In displayScrollableMenu, log the dimensions.
-func displayScrollableMenu(commands []Command) int {
-	termHeight := getTerminalHeight()
-	termWidth := getTerminalWidth()
-	if debugFile != nil {
-		fmt.Fprintf(debugFile, "DEBUG: Using terminal dimensions: height=%d, width=%d\n", termHeight, termWidth)
+// A non-empty banner is shown in red below the header. ok is false when the
+// user quit without choosing a command.
+func displayScrollableMenu(commands []Command, banner string) (choice menuChoice, ok bool) {
+	m := newMenu(commands, banner)
+	m.reload = func() {
+		commands, diags := LoadCommands()
+		m.setCommands(commands, diagnosticsBanner(diags))
 	}
 
-	// Calculate available space for menu items (accounting for header and footer)
-	headerLines := 4 // Header + blank line + title + blank line
-	footerLines := 2 // Help text + input prompt
-	maxVisibleItems := termHeight - headerLines - footerLines
-
-	if maxVisibleItems < 1 {
-		maxVisibleItems = 1
+	// draw measures the terminal every time so the menu follows resizes. mu
+	// keeps a redraw after a resize from running while a key is handled.
+	var mu sync.Mutex
+	closed := false
+	draw := func() {
+		termHeight := getTerminalHeight()
+		termWidth := getTerminalWidth()
+		if debugFile != nil {
+			fmt.Fprintf(debugFile, "DEBUG: Using terminal dimensions: height=%d, width=%d\n", termHeight, termWidth)
+		}
+		m.render(termHeight, termWidth)
 	}
-
-	currentPos := 0   // Current cursor position
-	scrollOffset := 0 // Current scroll offset
+	stop := onResize(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if !closed {
+			draw()
+		}
+	})
+	defer stop()
 
 	// Main display loop
 	for {
-		ClearScreen()
-		PrintHeader()
-		printLine(ColorYellow + "Quick Command Menu:" + ColorReset)
+		mu.Lock()
+		draw()
+		mu.Unlock()
 
-		// Display visible commands
-		displayEnd := scrollOffset + maxVisibleItems
-		if displayEnd > len(commands) {
-			displayEnd = len(commands)
+		k, err := readKey()
+		if err != nil {
+			break
 		}
-
-		// Show scroll indicator if needed
-		if scrollOffset > 0 {
-			printLine(ColorBlue + "  ▲ (more commands above)" + ColorReset)
+		mu.Lock()
+		done, index := m.handle(k)
+		closed = done
+		mu.Unlock()
+		if done {
+			if index < 0 {
+				return menuChoice{}, false
+			}
+			return m.choice(index), true
 		}
+	}
+	os.Stdout.Sync()
+	return menuChoice{}, false
+}
 
-		// Display commands in the visible window
-		for i := scrollOffset; i < displayEnd; i++ {
-			prefix := "  "
-			if i == currentPos {
-				prefix = ColorCyan + "→ " + ColorReset // Highlight current selection
+// menuGroup is a group header of the menu with the number of commands under
+// it.
+type menuGroup struct {
+	name  string
+	count int
+}
+
+// menu holds the state of the interactive command menu. rows holds the
+// indexes of the commands shown, in display order, and -(g+1) for the header
+// of groups[g]; cursor and offset are positions in rows.
+type menu struct {
+	commands   []Command
+	banner     string
+	showSource bool
+
+	rows     []int
+	matches  map[int]commandMatch
+	cursor   int // Current cursor position
+	offset   int // Current scroll offset
+	pageSize int // Number of rows that fit on screen
+
+	filter    string
+	filtering bool
+
+	// preview shows the highlighted command in full below the list.
+	preview bool
+
+	// edited is the command to run instead of the chosen one after the user
+	// changed it with i or picked a run to repeat with !; replay is set in
+	// the latter case.
+	edited *Command
+	replay bool
+
+	// groups are the group headers shown, in file order; collapsed holds the
+	// names of the groups whose commands are hidden. tag, when set, hides the
+	// commands without that tag.
+	groups    []menuGroup
+	collapsed map[string]bool
+	tag       string
+
+	// marked holds the indexes of the commands marked with space, in the
+	// order they were marked, which is the order they run in. runParallel is
+	// set when the user asked to run them at the same time; keepGoing when a
+	// failed command should not stop the ones after it.
+	marked      []int
+	runParallel bool
+	keepGoing   bool
+
+	// status is a one-off message shown above the help text, such as the
+	// outcome of an edit.
+	status string
+	// reload reads the commands files again after one was changed.
+	reload func()
+}
+
+func newMenu(commands []Command, banner string) *menu {
+	m := &menu{
+		commands:   commands,
+		banner:     banner,
+		showSource: multipleSources(commands),
+		pageSize:   1,
+	}
+	m.applyFilter()
+	return m
+}
+
+// setCommands replaces the commands shown, keeping the search and, as far as
+// possible, the cursor position.
+func (m *menu) setCommands(commands []Command, banner string) {
+	cursor := m.cursor
+	// The indexes of marked commands may have changed.
+	m.marked = nil
+	m.commands = commands
+	m.banner = banner
+	m.showSource = multipleSources(commands)
+	m.applyFilter()
+	m.move(cursor)
+}
+
+// applyFilter recomputes the rows from the search text, best match first,
+// and moves the cursor back to the top. Without a search, grouped commands
+// are shown under their group headers after the commands without a group.
+func (m *menu) applyFilter() {
+	m.rows = nil
+	m.matches = nil
+	m.groups = nil
+	if m.filter == "" {
+		var grouped []int
+		for i, c := range m.commands {
+			switch {
+			case m.tag != "" && !hasTag(c, m.tag):
+			case c.Group == "":
+				m.rows = append(m.rows, i)
+			default:
+				grouped = append(grouped, i)
 			}
+		}
+		m.addGroups(grouped)
+	} else {
+		m.matches = make(map[int]commandMatch)
+		for _, match := range fuzzyFilter(m.commands, m.filter) {
+			if m.tag == "" || hasTag(m.commands[match.index], m.tag) {
+				m.rows = append(m.rows, match.index)
+				m.matches[match.index] = match
+			}
+		}
+	}
+	m.cursor = 0
+	m.offset = 0
+}
+
+// addGroups adds a header row for each group of the commands at the given
+// indexes, in the order the groups first appear, followed by the group's
+// commands unless it is collapsed.
+func (m *menu) addGroups(indexes []int) {
+	var names []string
+	members := make(map[string][]int)
+	for _, i := range indexes {
+		name := m.commands[i].Group
+		if members[name] == nil {
+			names = append(names, name)
+		}
+		members[name] = append(members[name], i)
+	}
+	for _, name := range names {
+		m.groups = append(m.groups, menuGroup{name: name, count: len(members[name])})
+		m.rows = append(m.rows, -len(m.groups))
+		if !m.collapsed[name] {
+			m.rows = append(m.rows, members[name]...)
+		}
+	}
+}
+
+// selected returns the index of the highlighted command, or -1 when no
+// command is shown or the cursor is on a group header.
+func (m *menu) selected() int {
+	if m.cursor < 0 || m.cursor >= len(m.rows) || m.rows[m.cursor] < 0 {
+		return -1
+	}
+	return m.rows[m.cursor]
+}
+
+// selectedGroup returns the name of the group header under the cursor, or of
+// the group of the command under it, and whether the cursor is on the header.
+// The name is "" when groups are not shown or the command has no group.
+func (m *menu) selectedGroup() (name string, header bool) {
+	if m.cursor < 0 || m.cursor >= len(m.rows) || len(m.groups) == 0 {
+		return "", false
+	}
+	if row := m.rows[m.cursor]; row < 0 {
+		return m.groups[-row-1].name, true
+	}
+	return m.commands[m.rows[m.cursor]].Group, false
+}
+
+// setCollapsed hides or shows the commands of the named group and puts the
+// cursor on its header.
+func (m *menu) setCollapsed(name string, collapsed bool) {
+	if m.collapsed == nil {
+		m.collapsed = make(map[string]bool)
+	}
+	m.collapsed[name] = collapsed
+	m.applyFilter()
+	for pos, row := range m.rows {
+		if row < 0 && m.groups[-row-1].name == name {
+			m.move(pos)
+			break
+		}
+	}
+}
+
+// tags returns the tags and group names of the commands, each once, in the
+// order they first appear.
+func (m *menu) tags() []string {
+	var tags []string
+	seen := make(map[string]bool)
+	add := func(tag string) {
+		if key := strings.ToLower(tag); tag != "" && !seen[key] {
+			seen[key] = true
+			tags = append(tags, tag)
+		}
+	}
+	for _, c := range m.commands {
+		add(c.Group)
+		for _, tag := range c.Tags {
+			add(tag)
+		}
+	}
+	return tags
+}
+
+// nextTag moves the tag filter on to the next tag, or clears it after the
+// last one.
+func (m *menu) nextTag() {
+	tags := m.tags()
+	if len(tags) == 0 {
+		m.status = ColorYellow + "No command has tags or a group." + ColorReset
+		return
+	}
+	next := 0
+	for i, tag := range tags {
+		if strings.EqualFold(tag, m.tag) {
+			next = i + 1
+		}
+	}
+	m.tag = ""
+	if next < len(tags) {
+		m.tag = tags[next]
+	}
+	m.applyFilter()
+}
+
+// toggleMark marks the command at index, or unmarks it if it was marked.
+func (m *menu) toggleMark(index int) {
+	for i, marked := range m.marked {
+		if marked == index {
+			m.marked = append(m.marked[:i:i], m.marked[i+1:]...)
+			return
+		}
+	}
+	m.marked = append(m.marked, index)
+}
+
+// markOf returns the position of the command at index among the marked
+// commands, starting at 1, or 0 when it is not marked.
+func (m *menu) markOf(index int) int {
+	for i, marked := range m.marked {
+		if marked == index {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// choice returns what the menu was closed with when handle returned index:
+// the marked commands if there are any, and otherwise the command at index.
+func (m *menu) choice(index int) menuChoice {
+	if m.edited != nil {
+		return menuChoice{commands: []Command{*m.edited}, replay: m.replay}
+	}
+	if len(m.marked) == 0 {
+		return menuChoice{commands: []Command{m.commands[index]}}
+	}
+	choice := menuChoice{parallel: m.runParallel, keepGoing: m.keepGoing}
+	for _, i := range m.marked {
+		choice.commands = append(choice.commands, m.commands[i])
+	}
+	return choice
+}
+
+// markLine describes the marked commands and how Enter runs them, or returns
+// "" when no command is marked.
+func (m *menu) markLine() string {
+	if len(m.marked) == 0 {
+		return ""
+	}
+	onFailure := "stop"
+	if m.keepGoing {
+		onFailure = "keep going"
+	}
+	return fmt.Sprintf("%s%d marked | Enter: run in order | P: run in parallel | s: on failure %s%s", ColorPurple, len(m.marked), onFailure, ColorReset)
+}
 
-			cmdName := commands[i].Name
-			if len(cmdName) > 30 {
-				cmdName = cmdName[:27] + "..."
+// move moves the cursor by delta rows, scrolling to keep it visible.
+func (m *menu) move(delta int) {
+	m.cursor += delta
+	if m.cursor >= len(m.rows) {
+		m.cursor = len(m.rows) - 1
+	}
+	if m.cursor < 0 {
+		m.cursor = 0
+	}
+	if m.cursor < m.offset {
+		m.offset = m.cursor
+	}
+	if m.cursor >= m.offset+m.pageSize {
+		m.offset = m.cursor - m.pageSize + 1
+	}
+}
+
+// handle processes a key press. done is true when the menu should close;
+// index is then the chosen command, or -1 if the user quit.
+func (m *menu) handle(k keyPress) (done bool, index int) {
+	m.status = ""
+	if m.filtering {
+		switch k.code {
+		case keyRune:
+			if k.r >= 32 {
+				m.filter += string(k.r)
+				m.applyFilter()
+			}
+			return false, -1
+		case keyBackspace:
+			if m.filter == "" {
+				m.filtering = false
+			} else {
+				r := []rune(m.filter)
+				m.filter = string(r[:len(r)-1])
+				m.applyFilter()
 			}
+			return false, -1
+		case keyEsc:
+			m.filtering = false
+			m.filter = ""
+			m.applyFilter()
+			return false, -1
+		}
+	}
 
-			desc := commands[i].Description
-			// Calculate max description length and enforce a minimum length
-			maxDescLen := termWidth - 40
-			if maxDescLen < 10 {
-				maxDescLen = 10
+	switch k.code {
+	case keyCtrlC, keyEsc:
+		return true, -1
+	case keyEnter:
+		if len(m.marked) > 0 {
+			return true, m.marked[0]
+		}
+		if name, header := m.selectedGroup(); header {
+			m.setCollapsed(name, !m.collapsed[name])
+			break
+		}
+		if index := m.selected(); index >= 0 {
+			return true, index
+		}
+	case keyUp:
+		m.move(-1)
+	case keyDown:
+		m.move(1)
+	case keyLeft:
+		if name, _ := m.selectedGroup(); name != "" {
+			m.setCollapsed(name, true)
+		}
+	case keyRight:
+		if name, header := m.selectedGroup(); header {
+			m.setCollapsed(name, false)
+		}
+	case keyTab:
+		m.preview = !m.preview
+	case keyPageUp:
+		m.move(-m.pageSize)
+	case keyPageDown:
+		m.move(m.pageSize)
+	case keyRune:
+		switch k.r {
+		case 'q':
+			return true, -1
+		case ' ':
+			if name, header := m.selectedGroup(); header {
+				m.setCollapsed(name, !m.collapsed[name])
+			} else if index := m.selected(); index >= 0 {
+				m.toggleMark(index)
+				m.move(1)
 			}
-			if len(desc) > maxDescLen && maxDescLen > 3 {
-				desc = desc[:maxDescLen-3] + "..."
+		case 'P':
+			if len(m.marked) == 0 {
+				m.status = ColorYellow + "Mark commands with space to run them in parallel." + ColorReset
+				break
 			}
+			m.runParallel = true
+			return true, m.marked[0]
+		case 's':
+			m.keepGoing = !m.keepGoing
+		case 't':
+			m.nextTag()
+		case 'p':
+			m.preview = !m.preview
+		case '/':
+			m.filtering = true
+		case 'e':
+			if index := m.selected(); index >= 0 {
+				m.editCommand(m.commands[index])
+			}
+		case 'i':
+			if index := m.selected(); index >= 0 {
+				if c, ok := m.editBeforeRun(m.commands[index]); ok {
+					m.edited = &c
+					return true, index
+				}
+			}
+		case 'd':
+			if index := m.selected(); index >= 0 {
+				m.deleteCommand(m.commands[index])
+			}
+		case '!':
+			if c, ok := m.showHistory(); ok {
+				m.edited = &c
+				m.replay = true
+				return true, 0
+			}
+		case '1', '2', '3', '4', '5', '6', '7', '8', '9': // Number keys 1-9
+			num := int(k.r - '0')
+			if num <= len(m.commands) {
+				// A number runs its own command, not the marked ones.
+				m.marked = nil
+				return true, num - 1 // Return the index of the selected command
+			}
+		}
+	}
+	return false, -1
+}
 
-			line := fmt.Sprintf("%s[%d] %s: %s", prefix, i+1, ColorGreen+cmdName+ColorReset, desc)
-			printLine(line)
+// render draws the menu for a terminal of the given size.
+func (m *menu) render(termHeight, termWidth int) {
+	// Calculate available space for menu items (accounting for header and footer)
+	headerLines := 4 // Header + blank line + title + blank line
+	footerLines := 2 // Help text + input prompt
+	if m.banner != "" {
+		headerLines++
+	}
+	if m.filtering {
+		footerLines++
+	}
+	if m.status != "" {
+		footerLines++
+	}
+	markLine := m.markLine()
+	if markLine != "" {
+		footerLines++
+	}
+	// The details may take what is left after a few rows of the list.
+	details := m.details(termWidth-1, termHeight-headerLines-footerLines-minListRows)
+	footerLines += len(details)
+	m.pageSize = termHeight - headerLines - footerLines
+	if m.pageSize < 1 {
+		m.pageSize = 1
+	}
+	// Keep the cursor on screen if the terminal got smaller.
+	m.move(0)
+
+	ClearScreen()
+	PrintHeader()
+	if m.banner != "" {
+		printLine(ColorRed + m.banner + ColorReset)
+	}
+	title := "Quick Command Menu:"
+	if m.tag != "" {
+		title = "Quick Command Menu (tag: " + m.tag + "):"
+	}
+	printLine(ColorYellow + title + ColorReset)
+
+	// Display visible commands
+	displayEnd := m.offset + m.pageSize
+	if displayEnd > len(m.rows) {
+		displayEnd = len(m.rows)
+	}
+
+	// Show scroll indicator if needed
+	if m.offset > 0 {
+		printLine(ColorBlue + "  ▲ (more commands above)" + ColorReset)
+	}
+
+	// Display commands in the visible window
+	for pos := m.offset; pos < displayEnd; pos++ {
+		printLine(m.formatRow(pos, termWidth))
+	}
+	if len(m.rows) == 0 {
+		printLine(ColorBlue + "  (no matching commands)" + ColorReset)
+	}
+
+	// Show scroll indicator if needed
+	if displayEnd < len(m.rows) {
+		printLine(ColorBlue + "  ▼ (more commands below)" + ColorReset)
+	}
+
+	for _, line := range details {
+		printLine(line)
+	}
+	if markLine != "" {
+		printLine(markLine)
+	}
+	if m.status != "" {
+		printLine(m.status)
+	}
+
+	// Show help text
+	if m.filtering {
+		printLine(ColorCyan + "Search: " + ColorReset + m.filter + "▏")
+		printLine(ColorYellow + "Type to filter | Navigate: ↑/↓ arrows | Select: Enter | Clear: Esc" + ColorReset)
+	} else {
+		help := "Navigate: ↑/↓ arrows | Select: Enter or 1-9 | Mark: space | Run marked in parallel: P | Search: / | Preview: p/Tab | Edit: e | Edit and run: i | History: ! | Delete: d | Quit: q/Esc"
+		if len(m.groups) > 0 {
+			help += " | Fold group: ←/→"
+		}
+		if m.tag != "" || len(m.tags()) > 0 {
+			help += " | Tag: t"
 		}
+		printLine(ColorYellow + help + ColorReset)
+	}
+}
 
-		// Show scroll indicator if needed
-		if displayEnd < len(commands) {
-			fmt.Println(ColorBlue + "  ▼ (more commands below)" + ColorReset)
+// minListRows is the number of rows of the list that the preview pane leaves
+// visible.
+const minListRows = 3
+
+// details returns the lines describing the selected command below the list:
+// the preview pane when it is open, and otherwise the settings that are not
+// visible in its row. The preview is shortened to maxLines lines, or left out
+// when less than two fit.
+func (m *menu) details(width, maxLines int) []string {
+	i := m.selected()
+	if i < 0 {
+		return nil
+	}
+	c := m.commands[i]
+	if m.preview {
+		if maxLines < 2 {
+			return nil
 		}
+		lines := append([]string{ColorBlue + strings.Repeat("─", max(width, 1)) + ColorReset}, previewLines(c, width)...)
+		if len(lines) > maxLines {
+			lines = append(lines[:maxLines-1], ColorBlue+"  …"+ColorReset)
+		}
+		return lines
+	}
+	var lines []string
+	if c.Dir != "" {
+		lines = append(lines, ColorBlue+"  Runs in: "+relPath(workDir(c))+ColorReset)
+	}
+	if reason := confirmReason(c); reason != "" {
+		lines = append(lines, ColorRed+"  Asks for confirmation: "+reason+ColorReset)
+	}
+	return lines
+}
 
-		// Show help text
-		line := ColorYellow + "Navigate: ↑/↓ arrows | Select: Enter or 1-9 | Quit: q/Esc" + ColorReset
-		printLine(line)
+// editCommand asks for a new name, description and command for c and, once
+// confirmed, writes them to c's file. Multi-line commands keep their text;
+// use "aqc edit --cmd-file" to change them.
+func (m *menu) editCommand(c Command) {
+	updated := c
+	fields := []struct {
+		label string
+		value *string
+	}{
+		{"Name", &updated.Name},
+		{"Description", &updated.Description},
+		{"Command", &updated.Cmd},
+	}
+	for _, f := range fields {
+		if f.label == "Command" && strings.Contains(c.Cmd, "\n") {
+			continue
+		}
+		ClearScreen()
+		PrintHeader()
+		printLine(ColorYellow + "Edit " + c.Name + " (" + c.Source + "):" + ColorReset)
+		printLine(ColorYellow + "Enter: next | Esc: cancel" + ColorReset)
+		value, ok := readLine(ColorGreen+f.label+ColorReset+": ", *f.value, nil)
+		if !ok {
+			return
+		}
+		*f.value = value
+	}
+	if updated.Name == c.Name && updated.Description == c.Description && updated.Cmd == c.Cmd {
+		return
+	}
+	if updated.Name == "" || updated.Cmd == "" {
+		m.status = ColorRed + "The command and its name cannot be empty." + ColorReset
+		return
+	}
+	if !promptYesNo(ColorYellow + "Save changes to " + c.Source + "?" + ColorReset) {
+		return
+	}
+	if err := UpdateCommand(c, updated); err != nil {
+		m.status = ColorRed + "Error updating command: " + err.Error() + ColorReset
+		return
+	}
+	m.reload()
+	m.status = ColorGreen + "Updated " + updated.Name + "." + ColorReset
+}
 
-		// Read a single key
-		b := make([]byte, 3)
-		n, err := os.Stdin.Read(b)
-		if err != nil {
+// editBeforeRun lets the user change the command text of c for one run and,
+// if it was changed, offers to save the result as a new command in c's file.
+// ok is false when the user cancelled or saving failed.
+func (m *menu) editBeforeRun(c Command) (edited Command, ok bool) {
+	if strings.Contains(c.Cmd, "\n") {
+		m.status = ColorYellow + "Multi-line commands cannot be edited here; use \"aqc edit --cmd-file\" to change them." + ColorReset
+		return c, false
+	}
+	history := loadHistory(editHistoryFile())
+	ClearScreen()
+	PrintHeader()
+	printLine(ColorYellow + "Edit " + c.Name + " before running it:" + ColorReset)
+	printLine(ColorYellow + "Enter: run | ↑/↓: earlier edits | Ctrl+W: delete word | Ctrl+U: clear | Esc: cancel" + ColorReset)
+	cmd, ok := readLine(ColorGreen+"$ "+ColorReset, c.Cmd, history[c.Name])
+	if !ok || strings.TrimSpace(cmd) == "" {
+		return c, false
+	}
+	edited = c
+	edited.Cmd = cmd
+	if cmd == c.Cmd {
+		return edited, true
+	}
+	history.add(c.Name, cmd)
+	if err := history.saveTo(editHistoryFile()); err != nil && debugFile != nil {
+		fmt.Fprintf(debugFile, "Error saving edit history: %v\n", err)
+	}
+
+	if !promptYesNo(ColorYellow + "Save it as a new command in " + c.Source + "?" + ColorReset) {
+		return edited, true
+	}
+	// The name must not be taken in the file yet.
+	name := c.Name + " (edited)"
+	for {
+		name, ok = readLine(ColorGreen+"Name"+ColorReset+": ", name, nil)
+		if !ok || name == "" {
+			return edited, true
+		}
+		err := checkNameFree(c.Source, name)
+		if err == nil {
 			break
 		}
+		printLine(ColorRed + err.Error() + ColorReset)
+	}
+	edited.Name = name
+	edited.Line, edited.EndLine = 0, 0
+	if err := AppendCommandTo(c.Source, edited); err != nil {
+		m.status = ColorRed + "Error adding command: " + err.Error() + ColorReset
+		return c, false
+	}
+	return edited, true
+}
 
-		// Process key input
-		if n == 1 {
-			switch b[0] {
-			case 'q', 3, 27: // q, Ctrl+C, Esc
-				return -1
-			case 13: // Enter
-				return currentPos
-			case '1', '2', '3', '4', '5', '6', '7', '8', '9': // Number keys 1-9
-				num := int(b[0] - '0')
-				if num > 0 && num <= len(commands) {
-					return num - 1 // Return the index of the selected command
-				}
+// showHistory lists the recent runs and lets the user pick one to run again.
+// ok is false when there is no history or the user went back to the menu.
+func (m *menu) showHistory() (c Command, ok bool) {
+	recent, err := recentRuns()
+	if err != nil {
+		m.status = ColorRed + "Error reading history: " + err.Error() + ColorReset
+		return c, false
+	}
+	if len(recent) == 0 {
+		m.status = ColorYellow + "No runs recorded yet." + ColorReset
+		return c, false
+	}
+	// Header, title and help take five lines.
+	runs := selectRuns(recent, false, max(getTerminalHeight()-5, 1))
+	width := getTerminalWidth()
+	cursor := 0
+	for {
+		ClearScreen()
+		PrintHeader()
+		printLine(ColorYellow + "Recent runs, newest first:" + ColorReset)
+		for i, r := range runs {
+			prefix := "  "
+			if i == cursor {
+				prefix = ColorCyan + "→ " + ColorReset
 			}
-		} else if n >= 3 {
-			// Arrow keys send escape sequences
-			if b[0] == 27 && b[1] == 91 {
-				switch b[2] {
-				case 65: // Up arrow
-					if currentPos > 0 {
-						currentPos--
-						if currentPos < scrollOffset {
-							scrollOffset = currentPos
-						}
-					}
-				case 66: // Down arrow
-					if currentPos < len(commands)-1 {
-						currentPos++
-						if currentPos >= scrollOffset+maxVisibleItems {
-							scrollOffset = currentPos - maxVisibleItems + 1
-						}
-					}
-				case 53: // Page Up
-					currentPos -= maxVisibleItems
-					if currentPos < 0 {
-						currentPos = 0
-					}
-					scrollOffset = currentPos
-				case 54: // Page Down
-					currentPos += maxVisibleItems
-					if currentPos >= len(commands) {
-						currentPos = len(commands) - 1
-					}
-					if currentPos >= scrollOffset+maxVisibleItems {
-						scrollOffset = currentPos - maxVisibleItems + 1
-					}
-				}
+			printLine(prefix + formatRun(r, width-2, true))
+		}
+		printLine(ColorYellow + "Navigate: ↑/↓ arrows | Run again: Enter | Back: Esc/q/!" + ColorReset)
+		k, err := readKey()
+		if err != nil {
+			return c, false
+		}
+		switch {
+		case k.code == keyUp:
+			cursor = max(cursor-1, 0)
+		case k.code == keyDown:
+			cursor = min(cursor+1, len(runs)-1)
+		case k.code == keyEnter:
+			return rerunCommand(runs[cursor].historyEntry), true
+		case k.code == keyEsc, k.code == keyCtrlC, k.code == keyRune && (k.r == 'q' || k.r == '!'):
+			return c, false
+		}
+	}
+}
+
+// deleteCommand removes c from its file after asking for confirmation.
+func (m *menu) deleteCommand(c Command) {
+	printLine("")
+	if !promptYesNo(ColorRed + "Delete " + c.Name + " from " + c.Source + "?" + ColorReset) {
+		return
+	}
+	if err := RemoveCommand(c); err != nil {
+		m.status = ColorRed + "Error removing command: " + err.Error() + ColorReset
+		return
+	}
+	m.reload()
+	m.status = ColorGreen + "Deleted " + c.Name + "." + ColorReset
+}
+
+// formatRow renders the row at position pos, highlighting the characters
+// that matched the search.
+func (m *menu) formatRow(pos, termWidth int) string {
+	i := m.rows[pos]
+	prefix := "  "
+	if pos == m.cursor {
+		prefix = ColorCyan + "→ " + ColorReset // Highlight current selection
+	}
+	if i < 0 {
+		g := m.groups[-i-1]
+		arrow := "▾"
+		if m.collapsed[g.name] {
+			arrow = "▸"
+		}
+		return fmt.Sprintf("%s%s%s %s (%d)%s", prefix, ColorYellow, arrow, g.name, g.count, ColorReset)
+	}
+	c := m.commands[i]
+	match, matched := m.matches[i]
+	// Marked rows show their place in the run order.
+	if n := m.markOf(i); n > 0 {
+		prefix += ColorPurple + fmt.Sprintf("%-4s", fmt.Sprintf("(%d)", n)) + ColorReset
+	} else if len(m.marked) > 0 {
+		prefix += "    "
+	}
+
+	source := ""
+	if c.Global {
+		source = " [global]"
+	} else if m.showSource {
+		source = " (" + c.Source + ")"
+	}
+	// Calculate max description length and enforce a minimum length
+	maxDescLen := termWidth - 40 - len(source)
+	if maxDescLen < 10 {
+		maxDescLen = 10
+	}
+
+	var namePos, descPos []int
+	if matched {
+		switch match.field {
+		case matchName:
+			namePos = match.positions
+		case matchDescription:
+			descPos = match.positions
+		}
+	}
+	name := highlightTruncated(c.Name, 30, namePos, ColorGreen)
+	desc := highlightTruncated(c.Description, maxDescLen, descPos, "")
+
+	line := fmt.Sprintf("%s[%d] %s: %s%s", prefix, i+1, ColorGreen+name+ColorReset, desc, sourceColor(c)+source+ColorReset)
+	if matched && match.field == matchCmd {
+		// Show where the search matched when the command text isn't visible.
+		cmd := strings.ReplaceAll(c.Cmd, "\n", " ")
+		line += ColorBlue + "  $ " + highlightTruncated(cmd, 40, match.positions, ColorBlue) + ColorReset
+	}
+	return line
+}
+
+// highlightTruncated shortens s to n runes and highlights the runes at the
+// given positions that are still visible. base is the color s is shown in.
+func highlightTruncated(s string, n int, positions []int, base string) string {
+	short := truncate(s, n)
+	if short != s {
+		var visible []int
+		for _, p := range positions {
+			if p < n-1 {
+				visible = append(visible, p)
 			}
 		}
+		positions = visible
 	}
-	os.Stdout.Sync()
-	return -1
+	return highlightRunes(short, positions, ColorYellow, base)
 }
 
-func ListSubcommand() {}
+// sourceColor returns the color used for a command's source badge.
+func sourceColor(c Command) string {
+	if c.Global {
+		return ColorPurple
+	}
+	return ColorBlue
+}
@@ -2,6 +2,9 @@ package main
 
 import (
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -280,3 +283,337 @@ func TestDebugFileCreation(t *testing.T) {
 		t.Error("Debug file was not created")
 	}
 }
+
+func TestMenuSearch(t *testing.T) {
+	commands := []Command{
+		{Name: "List Files", Cmd: "ls -la"},
+		{Name: "Docker Build", Cmd: "docker build ."},
+		{Name: "Docker Push", Cmd: "docker push"},
+	}
+	m := newMenu(commands, "")
+	m.pageSize = 10
+	typeKeys := func(s string) {
+		for _, r := range s {
+			m.handle(keyPress{code: keyRune, r: r})
+		}
+	}
+
+	typeKeys("/push")
+	if !m.filtering || m.filter != "push" {
+		t.Fatalf("filter = %q (filtering %v), expected \"push\"", m.filter, m.filtering)
+	}
+	if len(m.rows) != 1 || m.selected() != 2 {
+		t.Fatalf("rows = %v, expected only Docker Push", m.rows)
+	}
+
+	m.handle(keyPress{code: keyBackspace})
+	m.handle(keyPress{code: keyBackspace})
+	m.handle(keyPress{code: keyBackspace})
+	m.handle(keyPress{code: keyBackspace})
+	typeKeys("dock")
+	if len(m.rows) != 2 {
+		t.Fatalf("rows = %v, expected the two Docker commands", m.rows)
+	}
+	m.handle(keyPress{code: keyDown})
+	if done, index := m.handle(keyPress{code: keyEnter}); !done || index != 2 {
+		t.Errorf("Enter on the second filtered row = (%v, %d), expected (true, 2)", done, index)
+	}
+
+	m.handle(keyPress{code: keyEsc})
+	if m.filtering || m.filter != "" || len(m.rows) != 3 {
+		t.Errorf("Esc should clear the search, got filter %q with %d rows", m.filter, len(m.rows))
+	}
+	if done, index := m.handle(keyPress{code: keyRune, r: '1'}); !done || index != 0 {
+		t.Errorf("Key 1 = (%v, %d), expected (true, 0)", done, index)
+	}
+}
+
+func TestMenuMoveScrolls(t *testing.T) {
+	commands := make([]Command, 10)
+	m := newMenu(commands, "")
+	m.pageSize = 3
+
+	m.move(4)
+	if m.cursor != 4 || m.offset != 2 {
+		t.Errorf("cursor/offset = %d/%d, expected 4/2", m.cursor, m.offset)
+	}
+	m.move(100)
+	if m.cursor != 9 || m.offset != 7 {
+		t.Errorf("cursor/offset = %d/%d, expected 9/7", m.cursor, m.offset)
+	}
+	m.move(-100)
+	if m.cursor != 0 || m.offset != 0 {
+		t.Errorf("cursor/offset = %d/%d, expected 0/0", m.cursor, m.offset)
+	}
+}
+
+func TestMenuMarksForParallelRun(t *testing.T) {
+	commands := []Command{{Name: "Api"}, {Name: "Web"}, {Name: "Watch"}}
+	m := newMenu(commands, "")
+	m.pageSize = 10
+	space := keyPress{code: keyRune, r: ' '}
+
+	if done, _ := m.handle(keyPress{code: keyRune, r: 'P'}); done || m.status == "" {
+		t.Errorf("P without marks closed the menu or said nothing")
+	}
+	m.move(2)
+	m.handle(space) // Watch
+	m.move(-2)
+	m.handle(space) // Api, cursor moves to Web
+	m.handle(space) // Web
+	m.move(-2)
+	m.handle(space) // Api again: unmarked
+	if m.markOf(2) != 1 || m.markOf(1) != 2 || m.markOf(0) != 0 {
+		t.Fatalf("marked = %v, expected Watch then Web", m.marked)
+	}
+
+	done, index := m.handle(keyPress{code: keyRune, r: 'P'})
+	if !done || index < 0 {
+		t.Fatalf("P = (%v, %d), expected the menu to close with a choice", done, index)
+	}
+	choice := m.choice(index)
+	if !choice.parallel || len(choice.commands) != 2 || choice.commands[0].Name != "Watch" || choice.commands[1].Name != "Web" {
+		t.Errorf("choice = %+v, expected Watch and Web in parallel", choice)
+	}
+}
+
+func TestMenuRunsMarkedInOrder(t *testing.T) {
+	commands := []Command{{Name: "Lint"}, {Name: "Build"}, {Name: "Test"}}
+	m := newMenu(commands, "")
+	m.pageSize = 10
+	space := keyPress{code: keyRune, r: ' '}
+
+	m.move(2)
+	m.handle(space) // Test
+	m.move(-2)
+	m.handle(space) // Lint
+	if !strings.Contains(m.formatRow(2, 80), "(1)") || !strings.Contains(m.formatRow(0, 80), "(2)") {
+		t.Errorf("Rows do not show the run order:\n%s\n%s", m.formatRow(2, 80), m.formatRow(0, 80))
+	}
+	if !strings.Contains(m.markLine(), "on failure stop") {
+		t.Errorf("markLine() = %q, expected stop on failure by default", m.markLine())
+	}
+	m.handle(keyPress{code: keyRune, r: 's'})
+	if !strings.Contains(m.markLine(), "on failure keep going") {
+		t.Errorf("markLine() after s = %q", m.markLine())
+	}
+
+	done, index := m.handle(keyPress{code: keyEnter})
+	if !done {
+		t.Fatal("Enter with marks did not close the menu")
+	}
+	choice := m.choice(index)
+	if choice.parallel || !choice.keepGoing || len(choice.commands) != 2 || choice.commands[0].Name != "Test" || choice.commands[1].Name != "Lint" {
+		t.Errorf("choice = %+v, expected Test then Lint, one after the other", choice)
+	}
+
+	// A number runs its own command even while others are marked.
+	m = newMenu(commands, "")
+	m.handle(space) // Lint
+	done, index = m.handle(keyPress{code: keyRune, r: '2'})
+	if choice := m.choice(index); !done || len(choice.commands) != 1 || choice.commands[0].Name != "Build" {
+		t.Errorf("choice after 2 = %+v, expected Build alone", choice)
+	}
+}
+
+func TestMenuGroups(t *testing.T) {
+	commands := []Command{
+		{Name: "Deploy", Cmd: "kubectl apply", Group: "deploy"},
+		{Name: "Build", Cmd: "make", Tags: []string{"ci"}},
+		{Name: "Rollback", Cmd: "kubectl rollout undo", Group: "deploy", Tags: []string{"ci"}},
+		{Name: "Shell", Cmd: "psql", Group: "db"},
+	}
+	m := newMenu(commands, "")
+	m.pageSize = 10
+	if expected := []int{1, -1, 0, 2, -2, 3}; !reflect.DeepEqual(m.rows, expected) {
+		t.Fatalf("rows = %v, expected %v", m.rows, expected)
+	}
+	if row := m.formatRow(1, 80); !strings.Contains(row, "▾ deploy (2)") {
+		t.Errorf("Group header row = %q", row)
+	}
+
+	m.move(1)
+	if m.selected() != -1 {
+		t.Errorf("selected() on a header = %d, expected -1", m.selected())
+	}
+	if done, _ := m.handle(keyPress{code: keyEnter}); done {
+		t.Fatal("Enter on a group header closed the menu")
+	}
+	if expected := []int{1, -1, -2, 3}; !reflect.DeepEqual(m.rows, expected) || m.cursor != 1 {
+		t.Fatalf("rows = %v (cursor %d), expected %v with the cursor on the header", m.rows, m.cursor, expected)
+	}
+	if row := m.formatRow(1, 80); !strings.Contains(row, "▸ deploy (2)") {
+		t.Errorf("Collapsed header row = %q", row)
+	}
+	m.handle(keyPress{code: keyRight})
+	m.move(2)
+	m.handle(keyPress{code: keyLeft})
+	if expected := []int{1, -1, -2, 3}; !reflect.DeepEqual(m.rows, expected) || m.cursor != 1 {
+		t.Errorf("Left on a grouped command gave rows %v (cursor %d), expected %v", m.rows, m.cursor, expected)
+	}
+
+	m.handle(keyPress{code: keyRune, r: 't'})
+	if m.tag != "deploy" || !reflect.DeepEqual(m.rows, []int{-1}) {
+		t.Errorf("First tag = %q with rows %v, expected deploy", m.tag, m.rows)
+	}
+	m.handle(keyPress{code: keyRune, r: 't'})
+	if m.tag != "ci" || !reflect.DeepEqual(m.rows, []int{1, -1}) {
+		t.Errorf("Second tag = %q with rows %v, expected ci", m.tag, m.rows)
+	}
+	m.handle(keyPress{code: keyRune, r: 't'})
+	m.handle(keyPress{code: keyRune, r: 't'})
+	if m.tag != "" || len(m.rows) != 4 {
+		t.Errorf("Cycling past the last tag left tag %q with rows %v", m.tag, m.rows)
+	}
+
+	for _, r := range "/sh" {
+		m.handle(keyPress{code: keyRune, r: r})
+	}
+	for _, row := range m.rows {
+		if row < 0 {
+			t.Errorf("A search shows group headers: %v", m.rows)
+		}
+	}
+}
+
+func TestMenuPreview(t *testing.T) {
+	commands := []Command{
+		{Name: "Build", Cmd: "make build", Description: "Build everything"},
+		{Name: "Script", Cmd: "set -e\nmake\nmake test\nmake install\nmake clean"},
+	}
+	m := newMenu(commands, "")
+	m.pageSize = 10
+	if lines := m.details(80, 20); len(lines) != 0 {
+		t.Errorf("details() without the preview = %q, expected nothing for a plain command", lines)
+	}
+
+	m.handle(keyPress{code: keyRune, r: 'p'})
+	if !m.preview {
+		t.Fatal("p did not open the preview")
+	}
+	text := strings.Join(m.details(80, 20), "\n")
+	if !strings.Contains(text, "make") || !strings.Contains(text, "Build everything") {
+		t.Errorf("The preview does not show the command and its description:\n%s", text)
+	}
+
+	m.move(1)
+	if lines := m.details(80, 4); len(lines) != 4 || !strings.Contains(lines[3], "…") {
+		t.Errorf("details() limited to 4 lines = %q, expected 3 lines and a …", lines)
+	}
+	if lines := m.details(80, 1); len(lines) != 0 {
+		t.Errorf("details() with no room = %q, expected nothing", lines)
+	}
+
+	m.handle(keyPress{code: keyTab})
+	if m.preview {
+		t.Error("Tab did not close the preview")
+	}
+}
+
+func TestMenuEditBeforeRun(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	path := filepath.Join(t.TempDir(), commandsFile)
+	if err := os.WriteFile(path, []byte("make build\n- Build\n---\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	commands, _ := ParseCommandFile(path, "make build\n- Build\n---\n")
+	defer func() { pendingKeys = nil }()
+
+	m := newMenu(commands, "")
+	pendingKeys = parseKeys([]byte(" -j4\rn"))
+	done, index := m.handle(keyPress{code: keyRune, r: 'i'})
+	if !done {
+		t.Fatal("i did not close the menu after editing")
+	}
+	if choice := m.choice(index); len(choice.commands) != 1 || choice.commands[0].Cmd != "make build -j4" || choice.commands[0].Name != "Build" {
+		t.Errorf("choice = %+v, expected the edited Build", choice)
+	}
+	if history := loadHistory(editHistoryFile()); len(history["Build"]) != 1 || history["Build"][0] != "make build -j4" {
+		t.Errorf("Edit history = %v", history)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "make build\n- Build\n---\n" {
+		t.Errorf("The file changed to %q without saving", data)
+	}
+
+	// Saving asks for a name, starting from the old one.
+	m = newMenu(commands, "")
+	pendingKeys = append(parseKeys([]byte(" -B\ry")), keyPress{code: keyDeleteToStart})
+	pendingKeys = append(pendingKeys, parseKeys([]byte("Rebuild\r"))...)
+	done, index = m.handle(keyPress{code: keyRune, r: 'i'})
+	if choice := m.choice(index); !done || choice.commands[0].Name != "Rebuild" {
+		t.Fatalf("choice = %+v, expected the saved Rebuild", choice)
+	}
+	data, _ := os.ReadFile(path)
+	saved, _ := ParseCommandFile(path, string(data))
+	if len(saved) != 2 || saved[1].Name != "Rebuild" || saved[1].Cmd != "make build -B" {
+		t.Errorf("Commands after saving = %+v", saved)
+	}
+
+	// A name the file already has is asked for again.
+	m = newMenu(commands, "")
+	pendingKeys = append(parseKeys([]byte(" -k\ry")), keyPress{code: keyDeleteToStart})
+	pendingKeys = append(pendingKeys, parseKeys([]byte("Rebuild\r"))...)
+	pendingKeys = append(pendingKeys, parseKeys([]byte(" all\r"))...)
+	done, index = m.handle(keyPress{code: keyRune, r: 'i'})
+	if choice := m.choice(index); !done || choice.commands[0].Name != "Rebuild all" {
+		t.Fatalf("choice = %+v, expected the saved Rebuild all", choice)
+	}
+	data, _ = os.ReadFile(path)
+	if saved, _ := ParseCommandFile(path, string(data)); len(saved) != 3 || saved[2].Name != "Rebuild all" {
+		t.Errorf("Commands after saving under a taken name = %+v", saved)
+	}
+
+	// Esc leaves the menu open.
+	m = newMenu(commands, "")
+	pendingKeys = parseKeys([]byte{27})
+	if done, _ := m.handle(keyPress{code: keyRune, r: 'i'}); done || m.edited != nil {
+		t.Error("Cancelling the edit closed the menu")
+	}
+}
+
+func TestMenuHistory(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	// The run is matched to the saved command in its own file.
+	source := filepath.Join(t.TempDir(), commandsFile)
+	if err := os.WriteFile(source, []byte(formatHeader(formatVersion)+"\nmake build\n- Build\nshell: bash\n---\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	commands := []Command{{Name: "Build", Cmd: "make build", Shell: "bash", Source: source}}
+	defer func() { pendingKeys = nil }()
+
+	m := newMenu(commands, "")
+	if done, _ := m.handle(keyPress{code: keyRune, r: '!'}); done || m.status == "" {
+		t.Fatalf("! without history: done = %v, status = %q", done, m.status)
+	}
+
+	for _, e := range []historyEntry{
+		{Name: "Build", Cmd: "make build -j4", Dir: "/src", Source: source},
+		{Name: "Old", Cmd: "echo old", Dir: "/src"},
+	} {
+		if err := appendHistory(historyFile(), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The newest run is first; ↓ and Enter pick the one before it.
+	m = newMenu(commands, "")
+	pendingKeys = []keyPress{{code: keyDown}, {code: keyEnter}}
+	done, index := m.handle(keyPress{code: keyRune, r: '!'})
+	if !done {
+		t.Fatal("Choosing a run did not close the menu")
+	}
+	choice := m.choice(index)
+	if len(choice.commands) != 1 || choice.commands[0].Cmd != "make build -j4" || choice.commands[0].Shell != "bash" || choice.commands[0].Dir != "/src" {
+		t.Errorf("choice = %+v, expected the Build run", choice)
+	}
+	if !choice.replay {
+		t.Error("A run from the history is not replayed as recorded")
+	}
+
+	// Esc goes back to the menu.
+	m = newMenu(commands, "")
+	pendingKeys = parseKeys([]byte{27})
+	if done, _ := m.handle(keyPress{code: keyRune, r: '!'}); done || m.edited != nil {
+		t.Error("Leaving the history closed the menu")
+	}
+}
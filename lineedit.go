@@ -0,0 +1,331 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Key codes for keys that are not printable characters.
+const (
+	keyRune = iota
+	keyEnter
+	keyEsc
+	keyCtrlC
+	keyBackspace
+	keyDelete
+	keyUp
+	keyDown
+	keyLeft
+	keyRight
+	keyHome
+	keyEnd
+	keyPageUp
+	keyPageDown
+	keyTab
+	keyWordLeft
+	keyWordRight
+	keyDeleteWordLeft
+	keyDeleteWordRight
+	keyDeleteToStart
+	keyDeleteToEnd
+	keyUnknown
+)
+
+// keyPress is a single decoded key. r is only set when code is keyRune.
+type keyPress struct {
+	code int
+	r    rune
+}
+
+// parseKeys decodes the bytes of one terminal read into key presses. A read
+// may contain several keys, for example when text is pasted.
+func parseKeys(b []byte) []keyPress {
+	var keys []keyPress
+	for len(b) > 0 {
+		switch {
+		case b[0] == 27 && len(b) >= 3 && (b[1] == '[' || b[1] == 'O'):
+			code, size := parseEscape(b)
+			keys = append(keys, keyPress{code: code})
+			b = b[size:]
+			continue
+		case b[0] == 27 && len(b) >= 2 && altKeys[b[1]] != 0:
+			keys = append(keys, keyPress{code: altKeys[b[1]]})
+			b = b[2:]
+			continue
+		case b[0] == 27:
+			keys = append(keys, keyPress{code: keyEsc})
+		case b[0] == 13 || b[0] == 10:
+			keys = append(keys, keyPress{code: keyEnter})
+		case b[0] == 3:
+			keys = append(keys, keyPress{code: keyCtrlC})
+		case b[0] == 127 || b[0] == 8:
+			keys = append(keys, keyPress{code: keyBackspace})
+		case b[0] == 9:
+			keys = append(keys, keyPress{code: keyTab})
+		case b[0] == 1:
+			keys = append(keys, keyPress{code: keyHome})
+		case b[0] == 5:
+			keys = append(keys, keyPress{code: keyEnd})
+		case b[0] == 23:
+			keys = append(keys, keyPress{code: keyDeleteWordLeft})
+		case b[0] == 21:
+			keys = append(keys, keyPress{code: keyDeleteToStart})
+		case b[0] == 11:
+			keys = append(keys, keyPress{code: keyDeleteToEnd})
+		case b[0] < 32:
+			keys = append(keys, keyPress{code: keyRune, r: rune(b[0])})
+		default:
+			r, size := utf8.DecodeRune(b)
+			keys = append(keys, keyPress{code: keyRune, r: r})
+			b = b[size:]
+			continue
+		}
+		b = b[1:]
+	}
+	return keys
+}
+
+// altKeys maps the byte that follows Esc when Alt is held with a key to the
+// key it stands for.
+var altKeys = map[byte]int{
+	'b': keyWordLeft,
+	'f': keyWordRight,
+	'd': keyDeleteWordRight,
+	127: keyDeleteWordLeft,
+}
+
+// parseEscape decodes a CSI or SS3 escape sequence at the start of b and
+// returns the key and the number of bytes it used.
+func parseEscape(b []byte) (int, int) {
+	// Find the final byte of the sequence: the first byte in 0x40-0x7E
+	// after the introducer.
+	end := 2
+	for end < len(b) && (b[end] < 0x40 || b[end] > 0x7e) {
+		end++
+	}
+	if end == len(b) {
+		return keyUnknown, len(b)
+	}
+	switch string(b[2 : end+1]) {
+	case "A":
+		return keyUp, end + 1
+	case "B":
+		return keyDown, end + 1
+	case "C":
+		return keyRight, end + 1
+	case "D":
+		return keyLeft, end + 1
+	case "1;5D", "1;3D":
+		return keyWordLeft, end + 1
+	case "1;5C", "1;3C":
+		return keyWordRight, end + 1
+	case "H", "1~", "7~":
+		return keyHome, end + 1
+	case "F", "4~", "8~":
+		return keyEnd, end + 1
+	case "3~":
+		return keyDelete, end + 1
+	case "5~":
+		return keyPageUp, end + 1
+	case "6~":
+		return keyPageDown, end + 1
+	}
+	return keyUnknown, end + 1
+}
+
+// pendingKeys holds keys that were read from stdin but not consumed yet, so
+// that keys typed or pasted quickly reach whichever prompt comes next.
+var pendingKeys []keyPress
+
+// readKey returns the next key pressed, blocking until one is available.
+func readKey() (keyPress, error) {
+	for len(pendingKeys) == 0 {
+		b := make([]byte, 256)
+		n, err := os.Stdin.Read(b)
+		if err != nil {
+			return keyPress{}, err
+		}
+		pendingKeys = parseKeys(b[:n])
+	}
+	k := pendingKeys[0]
+	pendingKeys = pendingKeys[1:]
+	return k, nil
+}
+
+// lineEditor holds the state of a single editable line. history is ordered
+// most recent first; histPos is -1 while editing the line itself.
+type lineEditor struct {
+	buf     []rune
+	cursor  int
+	history []string
+	histPos int
+	draft   string
+}
+
+func newLineEditor(initial string, history []string) *lineEditor {
+	buf := []rune(initial)
+	return &lineEditor{buf: buf, cursor: len(buf), history: history, histPos: -1}
+}
+
+// String returns the current content of the line.
+func (e *lineEditor) String() string {
+	return string(e.buf)
+}
+
+// handle applies a key to the line. done is set when the line is accepted
+// with Enter, cancel when it is abandoned with Esc or Ctrl+C.
+func (e *lineEditor) handle(k keyPress) (done, cancel bool) {
+	switch k.code {
+	case keyEnter:
+		return true, false
+	case keyEsc, keyCtrlC:
+		return false, true
+	case keyBackspace:
+		if e.cursor > 0 {
+			e.buf = append(e.buf[:e.cursor-1], e.buf[e.cursor:]...)
+			e.cursor--
+		}
+	case keyDelete:
+		if e.cursor < len(e.buf) {
+			e.buf = append(e.buf[:e.cursor], e.buf[e.cursor+1:]...)
+		}
+	case keyLeft:
+		if e.cursor > 0 {
+			e.cursor--
+		}
+	case keyRight:
+		if e.cursor < len(e.buf) {
+			e.cursor++
+		}
+	case keyHome:
+		e.cursor = 0
+	case keyEnd:
+		e.cursor = len(e.buf)
+	case keyWordLeft:
+		e.cursor = e.wordLeft(isWordSeparator)
+	case keyWordRight:
+		e.cursor = e.wordRight(isWordSeparator)
+	case keyDeleteWordLeft:
+		// Like a shell, Ctrl+W deletes back to white space.
+		e.cut(e.wordLeft(unicode.IsSpace), e.cursor)
+	case keyDeleteWordRight:
+		e.cut(e.cursor, e.wordRight(isWordSeparator))
+	case keyDeleteToStart:
+		e.cut(0, e.cursor)
+	case keyDeleteToEnd:
+		e.cut(e.cursor, len(e.buf))
+	case keyUp:
+		if e.histPos+1 < len(e.history) {
+			if e.histPos == -1 {
+				e.draft = e.String()
+			}
+			e.histPos++
+			e.set(e.history[e.histPos])
+		}
+	case keyDown:
+		if e.histPos > -1 {
+			e.histPos--
+			if e.histPos == -1 {
+				e.set(e.draft)
+			} else {
+				e.set(e.history[e.histPos])
+			}
+		}
+	case keyRune:
+		if k.r >= 32 {
+			e.buf = append(e.buf[:e.cursor], append([]rune{k.r}, e.buf[e.cursor:]...)...)
+			e.cursor++
+		}
+	}
+	return false, false
+}
+
+// isWordSeparator reports whether r separates the words that Alt+B, Alt+F
+// and Alt+D move over: anything but letters and digits.
+func isWordSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// wordLeft returns the start of the word before the cursor, where words are
+// separated by runes for which isSeparator is true.
+func (e *lineEditor) wordLeft(isSeparator func(rune) bool) int {
+	pos := e.cursor
+	for pos > 0 && isSeparator(e.buf[pos-1]) {
+		pos--
+	}
+	for pos > 0 && !isSeparator(e.buf[pos-1]) {
+		pos--
+	}
+	return pos
+}
+
+// wordRight returns the end of the word after the cursor.
+func (e *lineEditor) wordRight(isSeparator func(rune) bool) int {
+	pos := e.cursor
+	for pos < len(e.buf) && isSeparator(e.buf[pos]) {
+		pos++
+	}
+	for pos < len(e.buf) && !isSeparator(e.buf[pos]) {
+		pos++
+	}
+	return pos
+}
+
+// cut deletes the runes from start to end and puts the cursor where they
+// were.
+func (e *lineEditor) cut(start, end int) {
+	e.buf = append(e.buf[:start], e.buf[end:]...)
+	e.cursor = start
+}
+
+// set replaces the content of the line and moves the cursor to its end.
+func (e *lineEditor) set(s string) {
+	e.buf = []rune(s)
+	e.cursor = len(e.buf)
+}
+
+// render draws the prompt and the line on the current terminal row and puts
+// the terminal cursor at the editing position.
+func (e *lineEditor) render(prompt string) {
+	fmt.Print("\r\033[K" + prompt + e.String())
+	if back := len(e.buf) - e.cursor; back > 0 {
+		fmt.Printf("\033[%dD", back)
+	}
+}
+
+// readLine lets the user edit a line in raw mode, starting from initial, with
+// ↑/↓ stepping through history. Ctrl+←/→ (or Alt+B/F) move by words, Ctrl+W,
+// Alt+Backspace and Alt+D delete them, and Ctrl+U and Ctrl+K delete up to the
+// start and end of the line. ok is false if the user cancelled.
+func readLine(prompt, initial string, history []string) (string, bool) {
+	e := newLineEditor(initial, history)
+	for {
+		e.render(prompt)
+		k, err := readKey()
+		if err != nil {
+			return "", false
+		}
+		done, cancel := e.handle(k)
+		if cancel {
+			return "", false
+		}
+		if done {
+			fmt.Print("\r\n")
+			return e.String(), true
+		}
+	}
+}
+
+// promptYesNo shows question and waits for a key in raw mode. It returns true
+// only when the user presses y.
+func promptYesNo(question string) bool {
+	fmt.Print("\r\033[K" + question + " [y/N] ")
+	k, err := readKey()
+	fmt.Print("\r\n")
+	if err != nil {
+		return false
+	}
+	return k.code == keyRune && (k.r == 'y' || k.r == 'Y')
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		expected []keyPress
+	}{
+		{"letter", []byte("a"), []keyPress{{code: keyRune, r: 'a'}}},
+		{"pasted text", []byte("hi"), []keyPress{{code: keyRune, r: 'h'}, {code: keyRune, r: 'i'}}},
+		{"utf-8", []byte("é"), []keyPress{{code: keyRune, r: 'é'}}},
+		{"enter", []byte{13}, []keyPress{{code: keyEnter}}},
+		{"lone esc", []byte{27}, []keyPress{{code: keyEsc}}},
+		{"ctrl+c", []byte{3}, []keyPress{{code: keyCtrlC}}},
+		{"backspace", []byte{127}, []keyPress{{code: keyBackspace}}},
+		{"up arrow", []byte{27, 91, 65}, []keyPress{{code: keyUp}}},
+		{"down arrow", []byte{27, 91, 66}, []keyPress{{code: keyDown}}},
+		{"page up", []byte("\x1b[5~"), []keyPress{{code: keyPageUp}}},
+		{"delete", []byte("\x1b[3~"), []keyPress{{code: keyDelete}}},
+		{"arrow then letter", []byte("\x1b[Dx"), []keyPress{{code: keyLeft}, {code: keyRune, r: 'x'}}},
+		{"ctrl+w", []byte{23}, []keyPress{{code: keyDeleteWordLeft}}},
+		{"ctrl+left", []byte("\x1b[1;5D"), []keyPress{{code: keyWordLeft}}},
+		{"alt+f", []byte("\x1bf"), []keyPress{{code: keyWordRight}}},
+		{"alt+backspace", []byte{27, 127}, []keyPress{{code: keyDeleteWordLeft}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := parseKeys(tt.input)
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("parseKeys(%q) = %v, expected %v", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLineEditor(t *testing.T) {
+	type step struct {
+		key      keyPress
+		expected string
+		cursor   int
+	}
+	r := func(c rune) keyPress { return keyPress{code: keyRune, r: c} }
+	k := func(code int) keyPress { return keyPress{code: code} }
+
+	e := newLineEditor("ab", []string{"newest", "older"})
+	steps := []step{
+		{r('c'), "abc", 3},
+		{k(keyLeft), "abc", 2},
+		{r('X'), "abXc", 3},
+		{k(keyBackspace), "abc", 2},
+		{k(keyDelete), "ab", 2},
+		{k(keyHome), "ab", 0},
+		{k(keyEnd), "ab", 2},
+		{k(keyUp), "newest", 6},
+		{k(keyUp), "older", 5},
+		{k(keyUp), "older", 5},
+		{k(keyDown), "newest", 6},
+		{k(keyDown), "ab", 2},
+	}
+	for i, s := range steps {
+		e.handle(s.key)
+		if e.String() != s.expected || e.cursor != s.cursor {
+			t.Fatalf("step %d: line = %q cursor %d, expected %q cursor %d", i, e.String(), e.cursor, s.expected, s.cursor)
+		}
+	}
+
+	if done, cancel := e.handle(k(keyEnter)); !done || cancel {
+		t.Errorf("Enter: done=%v cancel=%v, expected done", done, cancel)
+	}
+	if done, cancel := e.handle(k(keyEsc)); done || !cancel {
+		t.Errorf("Esc: done=%v cancel=%v, expected cancel", done, cancel)
+	}
+}
+
+func TestLineEditorWords(t *testing.T) {
+	k := func(code int) keyPress { return keyPress{code: code} }
+	tests := []struct {
+		name     string
+		keys     []keyPress
+		expected string
+		cursor   int
+	}{
+		{"ctrl+w deletes back to white space", []keyPress{k(keyDeleteWordLeft)}, "git push origin ", 16},
+		{"twice", []keyPress{k(keyDeleteWordLeft), k(keyDeleteWordLeft)}, "git push ", 9},
+		{"word left stops at punctuation", []keyPress{k(keyWordLeft)}, "git push origin feature/login", 24},
+		{"alt+d deletes the next word", []keyPress{k(keyHome), k(keyWordRight), k(keyDeleteWordRight)}, "git origin feature/login", 3},
+		{"ctrl+u deletes to the start", []keyPress{k(keyWordLeft), k(keyDeleteToStart)}, "login", 0},
+		{"ctrl+k deletes to the end", []keyPress{k(keyHome), k(keyWordRight), k(keyDeleteToEnd)}, "git", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newLineEditor("git push origin feature/login", nil)
+			for _, key := range tt.keys {
+				e.handle(key)
+			}
+			if e.String() != tt.expected || e.cursor != tt.cursor {
+				t.Errorf("line = %q cursor %d, expected %q cursor %d", e.String(), e.cursor, tt.expected, tt.cursor)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// LintSubcommand handles the "lint" and "check" subcommands. It reports every
+// problem in the commands file and exits non-zero when there are any.
+func LintSubcommand() {
+	commands, diags := LoadCommands()
+	diags = append(diags, checkNeeds(commands)...)
+	if len(diags) == 0 {
+		fmt.Printf("%sNo problems found (%d commands).%s\n", ColorGreen, len(commands), ColorReset)
+		return
+	}
+	printDiagnostics(diags)
+	fmt.Printf("%s%d problem(s) found.%s\n", ColorRed, len(diags), ColorReset)
+	os.Exit(1)
+}
+
+// printDiagnostics prints each diagnostic on its own line.
+func printDiagnostics(diags []Diagnostic) {
+	for _, d := range diags {
+		fmt.Printf("%s%s%s\n", ColorYellow, d, ColorReset)
+	}
+}
+
+// diagnosticsBanner returns the warning shown above the interactive menu, or
+// an empty string when the file parsed cleanly.
+func diagnosticsBanner(diags []Diagnostic) string {
+	if len(diags) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("⚠ %d problem(s) in the command files; some commands may be missing. Run 'aqc lint' for details.", len(diags))
+}
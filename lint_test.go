@@ -0,0 +1,29 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiagnosticString(t *testing.T) {
+	d := Diagnostic{File: ".commands.aqc", Line: 7, Column: 3, Reason: "block skipped"}
+	expected := ".commands.aqc:7:3: block skipped"
+	if d.String() != expected {
+		t.Errorf("String() = %q, expected %q", d.String(), expected)
+	}
+}
+
+func TestDiagnosticsBanner(t *testing.T) {
+	if banner := diagnosticsBanner(nil); banner != "" {
+		t.Errorf("diagnosticsBanner(nil) = %q, expected empty", banner)
+	}
+
+	diags := []Diagnostic{{File: ".commands.aqc", Line: 1, Column: 1, Reason: "a"}, {File: ".commands.aqc", Line: 4, Column: 1, Reason: "b"}}
+	banner := diagnosticsBanner(diags)
+	if !strings.Contains(banner, "2 problem(s)") {
+		t.Errorf("Banner should mention the problem count, got %q", banner)
+	}
+	if !strings.Contains(banner, "aqc lint") {
+		t.Errorf("Banner should point to aqc lint, got %q", banner)
+	}
+}
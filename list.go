@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+	"gopkg.in/yaml.v3"
+)
+
+// listEntry is a command as printed by "aqc list". Index is the number used
+// to run it with "aqc N".
+type listEntry struct {
+	Index       int      `json:"index" yaml:"index"`
+	Name        string   `json:"name" yaml:"name"`
+	Description string   `json:"description" yaml:"description"`
+	Cmd         string   `json:"cmd" yaml:"cmd"`
+	Dir         string   `json:"dir,omitempty" yaml:"dir,omitempty"`
+	Env         []string `json:"env,omitempty" yaml:"env,omitempty"`
+	EnvFiles    []string `json:"env_file,omitempty" yaml:"env_file,omitempty"`
+	Shell       string   `json:"shell,omitempty" yaml:"shell,omitempty"`
+	Needs       []string `json:"needs,omitempty" yaml:"needs,omitempty"`
+	Group       string   `json:"group,omitempty" yaml:"group,omitempty"`
+	Tags        []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Confirm     *bool    `json:"confirm,omitempty" yaml:"confirm,omitempty"`
+	Source      string   `json:"source" yaml:"source"`
+	Global      bool     `json:"global,omitempty" yaml:"global,omitempty"`
+}
+
+// ListSubcommand handles the "list" subcommand to print the available commands.
+func ListSubcommand() {
+	listCmd := flag.NewFlagSet("list", flag.ExitOnError)
+	formatPtr := listCmd.String("format", "", "Output format: table, plain, json, yaml, tsv or names (default table on a terminal, plain otherwise)")
+	filterPtr := listCmd.String("filter", "", "Only list commands whose name, description, group or tags contain this text")
+	tagPtr := listCmd.String("tag", "", "Only list commands with this tag or in this group")
+	listCmd.Parse(os.Args[2:])
+
+	commands, diags := LoadCommands()
+	entries := filterEntries(tagEntries(listEntries(commands), *tagPtr), *filterPtr)
+
+	format := *formatPtr
+	isTerminal := term.IsTerminal(int(os.Stdout.Fd()))
+	if format == "" {
+		format = "plain"
+		if isTerminal {
+			format = "table"
+		}
+	}
+	width := 0
+	if format == "table" && isTerminal {
+		width = getTerminalWidth()
+	}
+
+	if err := writeList(os.Stdout, entries, format, width); err != nil {
+		fmt.Printf("%sError: %v%s\n", ColorRed, err, ColorReset)
+		os.Exit(1)
+	}
+	if len(diags) > 0 && format == "table" {
+		fmt.Println(ColorRed + diagnosticsBanner(diags) + ColorReset)
+	}
+}
+
+// listEntries numbers the commands in menu order.
+func listEntries(commands []Command) []listEntry {
+	entries := make([]listEntry, len(commands))
+	for i, c := range commands {
+		entries[i] = listEntry{
+			Index:       i + 1,
+			Name:        c.Name,
+			Description: c.Description,
+			Cmd:         c.Cmd,
+			Dir:         c.Dir,
+			Env:         c.Env,
+			EnvFiles:    c.EnvFiles,
+			Shell:       c.Shell,
+			Needs:       c.Needs,
+			Group:       c.Group,
+			Tags:        c.Tags,
+			Confirm:     c.Confirm,
+			Source:      c.Source,
+			Global:      c.Global,
+		}
+	}
+	return entries
+}
+
+// filterEntries keeps the entries whose name, description, group or one of
+// whose tags contains filter, ignoring case. Entries keep their original
+// numbers.
+func filterEntries(entries []listEntry, filter string) []listEntry {
+	if filter == "" {
+		return entries
+	}
+	filter = strings.ToLower(filter)
+	var matched []listEntry
+	for _, e := range entries {
+		fields := append([]string{e.Name, e.Description, e.Group}, e.Tags...)
+		for _, field := range fields {
+			if strings.Contains(strings.ToLower(field), filter) {
+				matched = append(matched, e)
+				break
+			}
+		}
+	}
+	return matched
+}
+
+// tagEntries keeps the entries with tag, as hasTag decides. Entries keep their
+// original numbers.
+func tagEntries(entries []listEntry, tag string) []listEntry {
+	if tag == "" {
+		return entries
+	}
+	var matched []listEntry
+	for _, e := range entries {
+		if hasTag(Command{Group: e.Group, Tags: e.Tags}, tag) {
+			matched = append(matched, e)
+		}
+	}
+	return matched
+}
+
+// writeList prints the entries in the given format. width limits the table
+// to the terminal width; 0 means no limit.
+func writeList(w io.Writer, entries []listEntry, format string, width int) error {
+	switch format {
+	case "table":
+		writeTable(w, entries, width, true)
+	case "plain":
+		writeTable(w, entries, 0, false)
+	case "json":
+		if entries == nil {
+			entries = []listEntry{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	case "yaml":
+		if entries == nil {
+			entries = []listEntry{}
+		}
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(entries); err != nil {
+			return err
+		}
+		return enc.Close()
+	case "tsv":
+		for _, e := range entries {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", e.Index, escapeTSV(e.Name), escapeTSV(e.Description), escapeTSV(e.Cmd))
+		}
+	case "names":
+		for _, e := range entries {
+			fmt.Fprintln(w, e.Name)
+		}
+	default:
+		return fmt.Errorf("unknown format %q (use table, plain, json, yaml, tsv or names)", format)
+	}
+	return nil
+}
+
+// writeTable prints the entries as aligned columns. When width is positive the
+// description and command columns are shortened so each row fits.
+func writeTable(w io.Writer, entries []listEntry, width int, color bool) {
+	showSource := false
+	for _, e := range entries {
+		if e.Global || e.Source != entries[0].Source {
+			showSource = true
+			break
+		}
+	}
+
+	headers := []string{"#", "NAME", "DESCRIPTION", "COMMAND"}
+	if showSource {
+		headers = append(headers, "SOURCE")
+	}
+	rows := make([][]string, len(entries))
+	for i, e := range entries {
+		rows[i] = []string{strconv.Itoa(e.Index), e.Name, e.Description, oneLine(e.Cmd)}
+		if showSource {
+			source := e.Source
+			if e.Global {
+				source = "global"
+			}
+			rows[i] = append(rows[i], source)
+		}
+	}
+
+	widths := make([]int, len(headers))
+	for i, h := range headers {
+		widths[i] = utf8.RuneCountInString(h)
+	}
+	for _, row := range rows {
+		for i, cell := range row {
+			if n := utf8.RuneCountInString(cell); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	if width > 0 {
+		fitColumns(widths, width)
+	}
+
+	colors := []string{ColorCyan, ColorGreen, "", "", ColorBlue}
+	printRow := func(cells []string, header bool) {
+		var b strings.Builder
+		for i, cell := range cells {
+			cell = padRight(truncate(cell, widths[i]), widths[i])
+			if i == len(cells)-1 {
+				cell = strings.TrimRight(cell, " ")
+			}
+			switch {
+			case color && header:
+				cell = ColorYellow + cell + ColorReset
+			case color && colors[i] != "":
+				cell = colors[i] + cell + ColorReset
+			}
+			if i > 0 {
+				b.WriteString("  ")
+			}
+			b.WriteString(cell)
+		}
+		fmt.Fprintln(w, b.String())
+	}
+
+	printRow(headers, true)
+	for _, row := range rows {
+		printRow(row, false)
+	}
+}
+
+// fitColumns shrinks the description (2) and command (3) columns so that all
+// columns and the gaps between them fit in width.
+func fitColumns(widths []int, width int) {
+	const minWidth = 10
+	total := 2 * (len(widths) - 1)
+	for _, w := range widths {
+		total += w
+	}
+	for over := total - width; over > 0; over-- {
+		// Take from whichever of the two flexible columns is wider.
+		i := 3
+		if widths[2] > widths[3] {
+			i = 2
+		}
+		if widths[i] <= minWidth {
+			break
+		}
+		widths[i]--
+	}
+}
+
+// oneLine shows a multi-line command on a single line.
+func oneLine(s string) string {
+	return strings.ReplaceAll(s, "\n", " ↵ ")
+}
+
+// truncate shortens s to at most n runes, marking the cut with "…".
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	if n < 1 {
+		return ""
+	}
+	return string([]rune(s)[:n-1]) + "…"
+}
+
+// padRight pads s with spaces to n runes.
+func padRight(s string, n int) string {
+	if pad := n - utf8.RuneCountInString(s); pad > 0 {
+		return s + strings.Repeat(" ", pad)
+	}
+	return s
+}
+
+// escapeTSV escapes characters that would break a tab-separated row.
+func escapeTSV(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n", "\r", "\\r").Replace(s)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func testEntries() []listEntry {
+	return listEntries([]Command{
+		{Cmd: "npm run build", Name: "Build", Description: "Build for production", Tags: []string{"ci"}, Source: ".commands.aqc"},
+		{Cmd: "npm test\nnpm run lint", Name: "Test", Description: "Run tests and lint", Source: ".commands.aqc"},
+		{Cmd: "sudo vpn up", Name: "VPN", Description: "Connect to the VPN", Group: "network", Source: "global.aqc", Global: true},
+	})
+}
+
+func TestFilterEntries(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   string
+		expected []int
+	}{
+		{"empty filter", "", []int{1, 2, 3}},
+		{"name match", "vpn", []int{3}},
+		{"description match is case-insensitive", "PRODUCTION", []int{1}},
+		{"tag match", "CI", []int{1}},
+		{"group match", "netw", []int{3}},
+		{"no match", "deploy", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var indexes []int
+			for _, e := range filterEntries(testEntries(), tt.filter) {
+				indexes = append(indexes, e.Index)
+			}
+			if len(indexes) != len(tt.expected) {
+				t.Fatalf("filterEntries() indexes = %v, expected %v", indexes, tt.expected)
+			}
+			for i := range indexes {
+				if indexes[i] != tt.expected[i] {
+					t.Errorf("filterEntries() indexes = %v, expected %v", indexes, tt.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteListFormats(t *testing.T) {
+	tests := []struct {
+		format   string
+		contains []string
+	}{
+		{"plain", []string{"#  NAME", "1  Build  Build for production", "npm test ↵ npm run lint", "global"}},
+		{"tsv", []string{"1\tBuild\tBuild for production\tnpm run build\n", "npm test\\nnpm run lint"}},
+		{"names", []string{"Build\nTest\nVPN\n"}},
+		{"yaml", []string{"- index: 1", "name: Build", "global: true"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writeList(&buf, testEntries(), tt.format, 0); err != nil {
+				t.Fatalf("writeList() error = %v", err)
+			}
+			for _, s := range tt.contains {
+				if !strings.Contains(buf.String(), s) {
+					t.Errorf("%s output should contain %q, got:\n%s", tt.format, s, buf.String())
+				}
+			}
+		})
+	}
+}
+
+func TestWriteListJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeList(&buf, testEntries(), "json", 0); err != nil {
+		t.Fatalf("writeList() error = %v", err)
+	}
+	var decoded []listEntry
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("Output is not valid JSON: %v", err)
+	}
+	if len(decoded) != 3 || decoded[1].Cmd != "npm test\nnpm run lint" {
+		t.Errorf("Decoded entries = %+v", decoded)
+	}
+
+	buf.Reset()
+	if err := writeList(&buf, nil, "json", 0); err != nil {
+		t.Fatalf("writeList() error = %v", err)
+	}
+	if strings.TrimSpace(buf.String()) != "[]" {
+		t.Errorf("Empty list should encode as [], got %q", buf.String())
+	}
+}
+
+func TestWriteListUnknownFormat(t *testing.T) {
+	if err := writeList(&bytes.Buffer{}, testEntries(), "xml", 0); err == nil {
+		t.Error("writeList() with an unknown format should fail")
+	}
+}
+
+func TestTableFitsWidth(t *testing.T) {
+	entries := listEntries([]Command{
+		{Cmd: strings.Repeat("x", 200), Name: "Long", Description: strings.Repeat("d", 200), Source: ".commands.aqc"},
+	})
+	var buf bytes.Buffer
+	writeTable(&buf, entries, 80, false)
+	for _, line := range strings.Split(strings.TrimRight(buf.String(), "\n"), "\n") {
+		if n := len([]rune(line)); n > 80 {
+			t.Errorf("Line is %d runes wide, expected at most 80: %q", n, line)
+		}
+	}
+	if !strings.Contains(buf.String(), "…") {
+		t.Error("Truncated cells should end with an ellipsis")
+	}
+}
+
+func TestTagEntries(t *testing.T) {
+	entries := listEntries([]Command{
+		{Cmd: "make", Name: "Build", Tags: []string{"ci", "local"}},
+		{Cmd: "kubectl apply", Name: "Deploy", Group: "deploy"},
+		{Cmd: "kubectl rollout undo", Name: "Rollback", Group: "deploy", Tags: []string{"CI"}},
+	})
+	tests := []struct {
+		tag      string
+		expected []int
+	}{
+		{"", []int{1, 2, 3}},
+		{"ci", []int{1, 3}},
+		{"Deploy", []int{2, 3}},
+		{"prod", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			var indexes []int
+			for _, e := range tagEntries(entries, tt.tag) {
+				indexes = append(indexes, e.Index)
+			}
+			if len(indexes) != len(tt.expected) {
+				t.Fatalf("tagEntries() indexes = %v, expected %v", indexes, tt.expected)
+			}
+			for i := range indexes {
+				if indexes[i] != tt.expected[i] {
+					t.Errorf("tagEntries() indexes = %v, expected %v", indexes, tt.expected)
+				}
+			}
+		})
+	}
+}
@@ -11,24 +11,42 @@ import (
 var Version = "dev"
 
 func main() {
+	os.Args = parseGlobalFlags(os.Args)
+
 	// If no subcommand is provided, use interactive mode.
 	if len(os.Args) < 2 {
-		InteractiveModeWithDefault()
-		return
+		os.Exit(InteractiveModeWithDefault())
 	}
 
 	// Switch based on the provided subcommand.
 	// Check if first argument is a number
 	if num, err := strconv.Atoi(os.Args[1]); err == nil {
-		InteractiveMode(num)
-		return
+		os.Exit(InteractiveMode(num))
 	}
 
 	switch os.Args[1] {
 	case "add":
 		AddSubcommand()
+	case "edit":
+		EditSubcommand()
+	case "remove", "rm":
+		RemoveSubcommand()
 	case "list":
 		ListSubcommand()
+	case "run":
+		RunSubcommand()
+	case "lint", "check":
+		LintSubcommand()
+	case "migrate":
+		MigrateSubcommand()
+	case "sort":
+		SortSubcommand()
+	case "convert":
+		ConvertSubcommand()
+	case "history":
+		HistorySubcommand()
+	case "rerun":
+		RerunSubcommand()
 	case "help", "--help", "-h":
 		PrintHelp()
 	case "version", "--version", "-v":
@@ -62,10 +80,43 @@ func PrintHelp() {
 	fmt.Println()
 	fmt.Println("Usage:")
 	fmt.Println("  aqc                     Launch interactive mode to select and run a command")
+	fmt.Println("  aqc <N> [--set k=v]     Run command number N, filling in {{k}} placeholders")
+	fmt.Println("                          (--yes skips the confirmation of destructive commands)")
 	fmt.Println("  aqc add --cmd=\"<command>\" --name=\"<name>\" --desc=\"<description>\"")
 	fmt.Println("                          Add a new command to the command file")
-	fmt.Println("  aqc list                List available commands")
+	fmt.Println("                          (use --cmd-file=<path|-> for multi-line commands and")
+	fmt.Println("                          --dir=<dir> to run it in another directory,")
+	fmt.Println("                          --env=NAME=value and --env-file=<file> to set its environment,")
+	fmt.Println("                          --shell=<shell> to run it with another shell,")
+	fmt.Println("                          --tags=a,b and --group=<group> to organize it,")
+	fmt.Println("                          --confirm=true to always ask before running it)")
+	fmt.Println("  aqc edit <name|N> [--cmd=...] [--name=...] [--desc=...] [--dir=...]")
+	fmt.Println("                          Change a saved command in its file")
+	fmt.Println("  aqc remove <name|N>     Delete a saved command from its file (alias: rm)")
+	fmt.Println("  aqc run <name|N> [--set k=v] [--yes] [-- args]")
+	fmt.Println("                          Run a command without the menu, exiting with its status")
+	fmt.Println("  aqc run --parallel <name|N>...")
+	fmt.Println("                          Run several commands at the same time with prefixed output")
+	fmt.Println("  aqc list [--format=table|plain|json|yaml|tsv|names] [--filter=<text>] [--tag=<tag>]")
+	fmt.Println("                          List available commands")
+	fmt.Println("  aqc history [--failed] [--json] [--limit=N]")
+	fmt.Println("                          Show the commands that were run, newest first")
+	fmt.Println("  aqc rerun [N] [--yes]   Run the last command again, or run N from aqc history")
+	fmt.Println("  aqc lint                Report problems in the command file (alias: check)")
+	fmt.Println("  aqc sort [--global] [file]")
+	fmt.Println("                          Order the commands of a file by name, keeping comments")
+	fmt.Println("  aqc convert --to=aqc|yaml|toml|json [--out=<path|->] [file]")
+	fmt.Println("                          Translate a command file into another format")
+	fmt.Println("  aqc migrate [--global] [file...]")
+	fmt.Println("                          Upgrade command files to the newest format, keeping a backup")
 	fmt.Println("  aqc help                Show this help message")
 	fmt.Println("  aqc version             Show the version information")
+	fmt.Println()
+	fmt.Println("Options:")
+	fmt.Println("  --file=<path>           Use this project command file instead of searching for")
+	fmt.Println("                          .commands.aqc (also set with AQC_FILE)")
+	fmt.Println("  aqc add --global ...    Add to the global file ($XDG_CONFIG_HOME/aqc/commands.aqc)")
+	fmt.Println("  AQC_SHELL=<shell>       Run commands with this shell instead of sh when their")
+	fmt.Println("                          file does not choose one")
 
 }
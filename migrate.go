@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// MigrateSubcommand handles the "migrate" subcommand to upgrade command files
+// to the newest file format.
+func MigrateSubcommand() {
+	migrateCmd := flag.NewFlagSet("migrate", flag.ExitOnError)
+	globalPtr := migrateCmd.Bool("global", false, "Also migrate the global commands file")
+	migrateCmd.Usage = func() {
+		fmt.Fprintln(migrateCmd.Output(), "Usage: aqc migrate [--global] [file...]")
+		migrateCmd.PrintDefaults()
+	}
+	migrateCmd.Parse(os.Args[2:])
+
+	paths := migrateCmd.Args()
+	if len(paths) == 0 {
+		cwd, err := os.Getwd()
+		if err != nil {
+			fmt.Printf("%sError getting current directory: %v%s\n", ColorRed, err, ColorReset)
+			os.Exit(1)
+		}
+		// Only files in the block format have versions.
+		for _, path := range projectCommandFiles(cwd) {
+			if formatForPath(path).name == "aqc" {
+				paths = append(paths, path)
+			}
+		}
+	}
+	if *globalPtr {
+		paths = append(paths, globalCommandsFile())
+	}
+	if len(paths) == 0 {
+		fmt.Println(ColorRed + "Error: no command files found to migrate." + ColorReset)
+		os.Exit(1)
+	}
+
+	failed := false
+	for _, path := range paths {
+		backup, err := MigrateFile(path)
+		path = relPath(path)
+		switch {
+		case err != nil:
+			fmt.Printf("%sError migrating %s: %v%s\n", ColorRed, path, err, ColorReset)
+			failed = true
+		case backup == "":
+			fmt.Printf("%s is already in format v%d.\n", path, formatVersion)
+		default:
+			fmt.Printf("%sMigrated %s to format v%d (backup in %s).%s\n", ColorGreen, path, formatVersion, relPath(backup), ColorReset)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// MigrateFile upgrades the command file at path in place to formatVersion and
+// returns the path of the backup it made of the original. It returns an empty
+// path when the file is already up to date. Files with problems are left
+// alone, since lines that are ignored today could change meaning.
+func MigrateFile(path string) (string, error) {
+	if format := formatForPath(path); format.name != "aqc" {
+		return "", fmt.Errorf("%s files have no format versions", strings.ToUpper(format.name))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	version, body, _, err := splitHeader(string(data))
+	if err != nil {
+		return "", err
+	}
+	if version == formatVersion {
+		return "", nil
+	}
+	before, diags := ParseCommandFile(path, string(data))
+	if len(diags) > 0 {
+		return "", fmt.Errorf("the file has %d problem(s); fix the ones 'aqc lint' reports first", len(diags))
+	}
+
+	// Version 2 only adds to the block syntax of version 1, so the blocks
+	// carry over unchanged and only the header is new.
+	migrated := formatHeader(formatVersion) + "\n" + strings.TrimLeft(body, "\n")
+	after, diags := ParseCommandFile(path, migrated)
+	if len(diags) > 0 || !sameCommands(before, after) {
+		return "", fmt.Errorf("the migrated file would not hold the same commands")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	backup := fmt.Sprintf("%s.v%d.bak", path, version)
+	if err := os.WriteFile(backup, data, info.Mode().Perm()); err != nil {
+		return "", err
+	}
+	return backup, writeFileAtomic(path, []byte(migrated))
+}
+
+// sameCommands reports whether a and b hold the same commands in the same
+// order, ignoring which file they were read from and where in it.
+func sameCommands(a, b []Command) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		x, y := a[i], b[i]
+		x.Source, x.Line, x.EndLine = "", 0, 0
+		y.Source, y.Line, y.EndLine = "", 0, 0
+		if !reflect.DeepEqual(x, y) {
+			return false
+		}
+	}
+	return true
+}
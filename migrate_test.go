@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrateFile(t *testing.T) {
+	v1 := "\nls -la\n- List: List files\n---\n```\nset -e\n---\nmake\n```\n- Build\n---\n"
+
+	t.Run("v1 file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), ".commands.aqc")
+		if err := os.WriteFile(path, []byte(v1), 0600); err != nil {
+			t.Fatal(err)
+		}
+		before, _ := ParseCommandFile(path, v1)
+
+		backup, err := MigrateFile(path)
+		if err != nil {
+			t.Fatalf("MigrateFile() error = %v", err)
+		}
+		if saved, _ := os.ReadFile(backup); string(saved) != v1 {
+			t.Errorf("backup = %q, expected the original file", saved)
+		}
+		data, _ := os.ReadFile(path)
+		if !strings.HasPrefix(string(data), "#aqc v2\n") {
+			t.Errorf("migrated file does not start with the header: %q", data)
+		}
+		after, diags := ParseCommandFile(path, string(data))
+		if len(diags) > 0 || !sameCommands(before, after) {
+			t.Errorf("commands after migration = %+v (%v), expected %+v", after, diags, before)
+		}
+		if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+			t.Errorf("mode = %v, expected 0600", info.Mode().Perm())
+		}
+
+		// A second run has nothing to do.
+		backup, err = MigrateFile(path)
+		if err != nil || backup != "" {
+			t.Errorf("MigrateFile() on a current file = %q, %v; expected no backup", backup, err)
+		}
+	})
+
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"file with problems", "ls\n- List\nstray line\n---\n"},
+		{"newer version", "#aqc v9\nls\n- List\n---\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), ".commands.aqc")
+			if err := os.WriteFile(path, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := MigrateFile(path); err == nil {
+				t.Error("MigrateFile() succeeded, expected an error")
+			}
+			if data, _ := os.ReadFile(path); string(data) != tt.data {
+				t.Errorf("file changed to %q", data)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// cycleError reports commands that need each other. path starts and ends with
+// the same command.
+type cycleError struct {
+	path []string
+}
+
+func (e *cycleError) Error() string {
+	return "dependency cycle: " + strings.Join(e.path, " -> ")
+}
+
+// commandKey identifies a loaded command independently of its position in a
+// list.
+type commandKey struct {
+	source string
+	line   int
+}
+
+func keyOf(c Command) commandKey {
+	return commandKey{c.Source, c.Line}
+}
+
+// findNeeded returns the command called name that c needs. Commands from c's
+// own file are preferred, and an exact name is preferred to one that only
+// differs in case.
+func findNeeded(commands []Command, c Command, name string) (Command, error) {
+	matchers := []func(Command) bool{
+		func(o Command) bool { return o.Source == c.Source && o.Name == name },
+		func(o Command) bool { return o.Name == name },
+		func(o Command) bool { return o.Source == c.Source && strings.EqualFold(o.Name, name) },
+		func(o Command) bool { return strings.EqualFold(o.Name, name) },
+	}
+	for _, matches := range matchers {
+		for _, o := range commands {
+			if matches(o) {
+				return o, nil
+			}
+		}
+	}
+	return Command{}, fmt.Errorf("%q needs %q, which is not a saved command", c.Name, name)
+}
+
+// planRun returns the commands to run for target, in order: the commands it
+// needs, each after the ones it needs itself, and then target. A command that
+// is needed several times runs once.
+func planRun(commands []Command, target Command) ([]Command, error) {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[commandKey]int)
+	var plan []Command
+	var path []string
+	var visit func(c Command) error
+	visit = func(c Command) error {
+		switch state[keyOf(c)] {
+		case done:
+			return nil
+		case visiting:
+			start := 0
+			for i, name := range path {
+				if name == c.Name {
+					start = i
+				}
+			}
+			return &cycleError{path: append(append([]string(nil), path[start:]...), c.Name)}
+		}
+		state[keyOf(c)] = visiting
+		path = append(path, c.Name)
+		for _, name := range c.Needs {
+			needed, err := findNeeded(commands, c, name)
+			if err != nil {
+				return err
+			}
+			if err := visit(needed); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[keyOf(c)] = done
+		plan = append(plan, c)
+		return nil
+	}
+	if err := visit(target); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// prepareNeeds returns the commands that must run before target, with their
+// placeholders filled from values or their defaults.
+func prepareNeeds(commands []Command, target Command, values map[string]string) ([]Command, error) {
+	plan, err := planRun(commands, target)
+	if err != nil {
+		return nil, err
+	}
+	needs := plan[:len(plan)-1]
+	for i, c := range needs {
+		prepared, missing, err := applyValues(c, values, true)
+		if err != nil {
+			return nil, err
+		}
+		if len(missing) > 0 {
+			return nil, fmt.Errorf("%q needs a value for {{%s}}; pass --set %s=<value>", c.Name, missing[0].Name, missing[0].Name)
+		}
+		needs[i] = prepared
+	}
+	return needs, nil
+}
+
+// sequenceSteps returns the commands to run to run chosen one after the
+// other: each after the commands it needs, with their placeholders filled from
+// values. Every command runs once, at its first place.
+func sequenceSteps(commands, chosen []Command, values map[string]string) ([]Command, error) {
+	seen := make(map[commandKey]bool)
+	var steps []Command
+	for _, c := range chosen {
+		needs, err := prepareNeeds(commands, c, values)
+		if err != nil {
+			return nil, err
+		}
+		for _, step := range append(needs, c) {
+			if !seen[keyOf(step)] {
+				seen[keyOf(step)] = true
+				steps = append(steps, step)
+			}
+		}
+	}
+	return steps, nil
+}
+
+// checkNeeds reports the needs that name no saved command and the commands
+// that are part of a dependency cycle.
+func checkNeeds(commands []Command) []Diagnostic {
+	var diags []Diagnostic
+	for _, c := range commands {
+		report := func(err error) {
+			diags = append(diags, Diagnostic{File: c.Source, Line: c.Line, Column: 1, Reason: err.Error()})
+		}
+		for _, name := range c.Needs {
+			if _, err := findNeeded(commands, c, name); err != nil {
+				report(err)
+			}
+		}
+		if _, err := planRun(commands, c); err != nil {
+			if cycle, ok := err.(*cycleError); ok && cycle.path[0] == c.Name {
+				report(err)
+			}
+		}
+	}
+	return diags
+}
+
+// runSteps runs the commands in order. It stops at the first one that fails
+// unless keepGoing is set. When there are several, each is announced before it
+// runs and a summary with timings is printed to stderr at the end. It returns
+// the result of the first command that failed, or of the last one.
+func runSteps(steps []Command, keepGoing bool) RunResult {
+	if len(steps) == 1 {
+		return RunCommand(steps[0])
+	}
+	var results []RunResult
+	failed := -1
+	for i, c := range steps {
+		fmt.Fprintf(os.Stderr, "%s[%d/%d] %s:%s %s\n", ColorCyan, i+1, len(steps), c.Name, ColorReset, c.Cmd)
+		result := RunCommand(c)
+		results = append(results, result)
+		if !result.Success() && failed < 0 {
+			failed = i
+		}
+		if failed >= 0 && !keepGoing {
+			break
+		}
+	}
+	var total time.Duration
+	for _, r := range results {
+		total += r.Duration
+	}
+	printSummary(os.Stderr, steps, results, total)
+	if failed >= 0 {
+		return results[failed]
+	}
+	return results[len(results)-1]
+}
+
+// printSummary writes a line for each step with its outcome and how long it
+// took, and the total time. Steps without a result were skipped.
+func printSummary(w io.Writer, steps []Command, results []RunResult, total time.Duration) {
+	width := 0
+	for _, c := range steps {
+		width = max(width, utf8.RuneCountInString(c.Name))
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Summary:")
+	for i, c := range steps {
+		name := padRight(c.Name, width)
+		if i >= len(results) {
+			fmt.Fprintf(w, "  %sskipped%s  %s\n", ColorYellow, ColorReset, name)
+			continue
+		}
+		r := results[i]
+		if r.Success() {
+			fmt.Fprintf(w, "  %sok%s       %s  %s\n", ColorGreen, ColorReset, name, formatDuration(r.Duration))
+		} else {
+			fmt.Fprintf(w, "  %sfailed%s   %s  %s  (%s)\n", ColorRed, ColorReset, name, formatDuration(r.Duration), r)
+		}
+	}
+	fmt.Fprintf(w, "  Total %s\n", formatDuration(total))
+}
+
+// formatDuration rounds d for display, e.g. "1.25s" or "340ms".
+func formatDuration(d time.Duration) string {
+	switch {
+	case d >= time.Minute:
+		return d.Round(time.Second).String()
+	case d >= time.Second:
+		return d.Round(10 * time.Millisecond).String()
+	}
+	return d.Round(time.Millisecond).String()
+}
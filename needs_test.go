@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// release is the example from the README: Release needs Test and Build,
+// which both need Install.
+func release(source string) []Command {
+	return []Command{
+		{Name: "Install", Cmd: "install", Source: source, Line: 1},
+		{Name: "Test", Cmd: "test", Needs: []string{"Install"}, Source: source, Line: 4},
+		{Name: "Build", Cmd: "build", Needs: []string{"install"}, Source: source, Line: 8},
+		{Name: "Release", Cmd: "tag", Needs: []string{"Test", "Build"}, Source: source, Line: 12},
+	}
+}
+
+func planNames(plan []Command) string {
+	var names []string
+	for _, c := range plan {
+		names = append(names, c.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestPlanRun(t *testing.T) {
+	commands := release("f")
+	tests := []struct {
+		name     string
+		target   int
+		expected string
+	}{
+		{"no needs", 0, "Install"},
+		{"one need", 1, "Install,Test"},
+		{"shared need runs once", 3, "Install,Test,Build,Release"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := planRun(commands, commands[tt.target])
+			if err != nil {
+				t.Fatalf("planRun() error = %v", err)
+			}
+			if got := planNames(plan); got != tt.expected {
+				t.Errorf("planRun() = %s, expected %s", got, tt.expected)
+			}
+		})
+	}
+
+	t.Run("own file first", func(t *testing.T) {
+		commands := append([]Command{{Name: "Install", Cmd: "other", Source: "g", Line: 1}}, release("f")...)
+		plan, _ := planRun(commands, commands[2])
+		if plan[0].Cmd != "install" {
+			t.Errorf("Needed command came from %s, expected the command's own file", plan[0].Source)
+		}
+	})
+	t.Run("unknown name", func(t *testing.T) {
+		commands := []Command{{Name: "Deploy", Needs: []string{"Build"}, Source: "f", Line: 1}}
+		if _, err := planRun(commands, commands[0]); err == nil || !strings.Contains(err.Error(), `"Build"`) {
+			t.Errorf("planRun() error = %v, expected one naming Build", err)
+		}
+	})
+	t.Run("cycle", func(t *testing.T) {
+		commands := []Command{
+			{Name: "A", Needs: []string{"B"}, Source: "f", Line: 1},
+			{Name: "B", Needs: []string{"C"}, Source: "f", Line: 2},
+			{Name: "C", Needs: []string{"B"}, Source: "f", Line: 3},
+		}
+		_, err := planRun(commands, commands[0])
+		var cycle *cycleError
+		if !errors.As(err, &cycle) || err.Error() != "dependency cycle: B -> C -> B" {
+			t.Errorf("planRun() error = %v, expected the cycle B -> C -> B", err)
+		}
+		if diags := checkNeeds(commands); len(diags) != 2 {
+			t.Errorf("checkNeeds() = %v, expected one diagnostic for each of B and C", diags)
+		}
+	})
+}
+
+func TestPrepareNeeds(t *testing.T) {
+	commands := []Command{
+		{Name: "Build", Cmd: "make {{target:all}}", Source: "f", Line: 1},
+		{Name: "Push", Cmd: "push {{tag}}", Source: "f", Line: 2},
+		{Name: "Deploy", Cmd: "deploy", Needs: []string{"Build"}, Source: "f", Line: 3},
+		{Name: "Release", Cmd: "release", Needs: []string{"Push"}, Source: "f", Line: 4},
+	}
+	needs, err := prepareNeeds(commands, commands[2], map[string]string{})
+	if err != nil || len(needs) != 1 || needs[0].Cmd != "make all" {
+		t.Errorf("prepareNeeds() = %+v, %v; expected Build with its default", needs, err)
+	}
+	if _, err := prepareNeeds(commands, commands[3], map[string]string{}); err == nil {
+		t.Error("prepareNeeds() without a value for {{tag}} succeeded")
+	}
+	needs, err = prepareNeeds(commands, commands[3], map[string]string{"tag": "v1"})
+	if err != nil || needs[0].Cmd != "push v1" {
+		t.Errorf("prepareNeeds() = %+v, %v; expected the --set value", needs, err)
+	}
+}
+
+func TestRunStepsStopsAtFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, commandsFile)
+	steps := []Command{
+		{Name: "First", Cmd: "touch first", Source: source},
+		{Name: "Fail", Cmd: "exit 3", Source: source},
+		{Name: "Never", Cmd: "touch never", Source: source},
+	}
+	stderr := os.Stderr
+	os.Stderr, _ = os.Open(os.DevNull)
+	result := runSteps(steps, false)
+	os.Stderr.Close()
+	os.Stderr = stderr
+
+	if result.ExitCode != 3 {
+		t.Errorf("runSteps() = %s, expected exit status 3", result)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "first")); err != nil {
+		t.Errorf("First step did not run: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "never")); err == nil {
+		t.Error("Step after the failure ran")
+	}
+}
+
+func TestPrintSummary(t *testing.T) {
+	steps := []Command{{Name: "Install"}, {Name: "Test"}, {Name: "Tag"}}
+	results := []RunResult{{Duration: 1500 * time.Millisecond}, {ExitCode: 2, Duration: 250 * time.Millisecond}}
+	var buf bytes.Buffer
+	printSummary(&buf, steps, results, 1750*time.Millisecond)
+	out := buf.String()
+	for _, want := range []string{"Install  1.5s", "Test     250ms  (exit status 2)", "Tag", "skipped", "Total 1.75s"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Summary does not contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestSequenceSteps(t *testing.T) {
+	commands := release("f")
+	steps, err := sequenceSteps(commands, []Command{commands[2], commands[1], commands[0]}, map[string]string{})
+	if err != nil {
+		t.Fatalf("sequenceSteps() error = %v", err)
+	}
+	if got := planNames(steps); got != "Install,Build,Test" {
+		t.Errorf("sequenceSteps() = %s, expected each command once, needs first", got)
+	}
+}
+
+func TestRunStepsKeepGoing(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, commandsFile)
+	steps := []Command{
+		{Name: "Fail", Cmd: "exit 4", Source: source},
+		{Name: "After", Cmd: "touch after", Source: source},
+	}
+	stderr := os.Stderr
+	os.Stderr, _ = os.Open(os.DevNull)
+	result := runSteps(steps, true)
+	os.Stderr.Close()
+	os.Stderr = stderr
+
+	if result.ExitCode != 4 {
+		t.Errorf("runSteps() = %s, expected the status of the failed step", result)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "after")); err != nil {
+		t.Errorf("Step after the failure did not run: %v", err)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+// prefixColors are the colors of the name prefixes of commands that run in
+// parallel, in turn.
+var prefixColors = []string{ColorCyan, ColorGreen, ColorYellow, ColorBlue, ColorPurple}
+
+// prefixWriter writes what it is given to out line by line, starting each
+// line with prefix. Writers that share mu never interleave within a line.
+type prefixWriter struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.writeLine(w.buf[:i+1])
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush writes a last line that did not end in a newline.
+func (w *prefixWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.writeLine(append(w.buf, '\n'))
+		w.buf = nil
+	}
+}
+
+func (w *prefixWriter) writeLine(line []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	io.WriteString(w.out, w.prefix)
+	w.out.Write(line)
+}
+
+// RunParallel runs the commands at the same time and waits for all of them.
+// Each line they print goes to stdout or stderr behind the command's name in
+// its own color, and a line reports how each command ended. Each run is
+// recorded in the run history. The commands do not read the terminal. They
+// run in process groups of their own, and AQC passes Ctrl+C and termination
+// signals on to each, whether they come from the terminal or from kill.
+func RunParallel(commands []Command, stdout, stderr io.Writer) []RunResult {
+	width := 0
+	for _, c := range commands {
+		width = max(width, utf8.RuneCountInString(c.Name))
+	}
+	var mu, startedMu sync.Mutex
+	var wg sync.WaitGroup
+	var started []*exec.Cmd
+	// Signals are forwarded from the start. The last one is kept: commands
+	// are no longer started once it is set, and one that was starting just
+	// then gets it as soon as it runs.
+	var received os.Signal
+	stop := forwardSignals(func(sig os.Signal) []*exec.Cmd {
+		startedMu.Lock()
+		defer startedMu.Unlock()
+		received = sig
+		return append([]*exec.Cmd(nil), started...)
+	}, true)
+	defer stop()
+	results := make([]RunResult, len(commands))
+	for i, c := range commands {
+		prefix := prefixColors[i%len(prefixColors)] + padRight(c.Name, width) + " |" + ColorReset + " "
+		out := &prefixWriter{mu: &mu, out: stdout, prefix: prefix}
+		errOut := &prefixWriter{mu: &mu, out: stderr, prefix: prefix}
+		startedMu.Lock()
+		sig := received
+		startedMu.Unlock()
+		if sig != nil {
+			results[i] = notStarted(sig)
+			fmt.Fprintf(errOut, "%s%s%s\n", ColorRed, results[i], ColorReset)
+			continue
+		}
+		cmd, err := commandExec(c)
+		if err != nil {
+			results[i] = RunResult{ExitCode: 1, Err: err}
+			recordRun(c, results[i])
+			fmt.Fprintf(errOut, "%s%s%s\n", ColorRed, err, ColorReset)
+			continue
+		}
+		cmd.Stdout = out
+		cmd.Stderr = errOut
+		ownProcessGroup(cmd)
+		start := time.Now()
+		if err := cmd.Start(); err != nil {
+			results[i] = RunResult{ExitCode: 127, Err: err}
+			recordRun(c, results[i])
+			fmt.Fprintf(errOut, "%s%s%s\n", ColorRed, err, ColorReset)
+			continue
+		}
+		startedMu.Lock()
+		started = append(started, cmd)
+		if received != nil {
+			signalGroup(cmd, received)
+		}
+		startedMu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := cmd.Wait()
+			results[i] = runResult(cmd.ProcessState, err, time.Since(start))
+			recordRun(c, results[i])
+			out.Flush()
+			errOut.Flush()
+			color := ColorGreen
+			if !results[i].Success() {
+				color = ColorRed
+			}
+			fmt.Fprintf(errOut, "%s%s%s\n", color, results[i], ColorReset)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// notStarted is the result of a command that was not started because AQC
+// received sig first. Its exit status is the one of a command killed by sig.
+func notStarted(sig os.Signal) RunResult {
+	result := RunResult{ExitCode: 1, Signal: sig, Err: fmt.Errorf("not started: received %s", sig)}
+	if s, ok := sig.(syscall.Signal); ok {
+		result.ExitCode = 128 + int(s)
+	}
+	return result
+}
+
+// runParallelSteps runs needs one after the other and then, if they all
+// succeeded, the selected commands in parallel. A summary of the parallel
+// run is printed to stderr. It returns the first non-zero exit status, or 0.
+func runParallelSteps(needs, selected []Command) int {
+	if len(needs) > 0 {
+		if result := runSteps(needs, false); !result.Success() {
+			return result.ExitCode
+		}
+	}
+	start := time.Now()
+	results := RunParallel(selected, os.Stdout, os.Stderr)
+	printSummary(os.Stderr, selected, results, time.Since(start))
+	for _, r := range results {
+		if !r.Success() {
+			return r.ExitCode
+		}
+	}
+	return 0
+}
+
+// parallelNeeds returns the commands that the selected commands need, in
+// order and each once, with their placeholders filled from values. Commands
+// that are selected themselves are left out, since they run in parallel
+// anyway.
+func parallelNeeds(commands, selected []Command, values map[string]string) ([]Command, error) {
+	skip := make(map[commandKey]bool)
+	for _, c := range selected {
+		skip[keyOf(c)] = true
+	}
+	var needs []Command
+	for _, c := range selected {
+		steps, err := prepareNeeds(commands, c, values)
+		if err != nil {
+			return nil, err
+		}
+		for _, step := range steps {
+			if !skip[keyOf(step)] {
+				skip[keyOf(step)] = true
+				needs = append(needs, step)
+			}
+		}
+	}
+	return needs, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	var mu sync.Mutex
+	var buf bytes.Buffer
+	w := &prefixWriter{mu: &mu, out: &buf, prefix: "api | "}
+
+	w.Write([]byte("one\ntw"))
+	w.Write([]byte("o\nthree"))
+	if got := buf.String(); got != "api | one\napi | two\n" {
+		t.Errorf("Before Flush: %q", got)
+	}
+	w.Flush()
+	w.Flush()
+	if got := buf.String(); got != "api | one\napi | two\napi | three\n" {
+		t.Errorf("After Flush: %q", got)
+	}
+}
+
+func TestRunParallel(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+
+	source := filepath.Join(t.TempDir(), commandsFile)
+	commands := []Command{
+		{Name: "Api", Cmd: "echo ready; echo oops >&2", Source: source},
+		{Name: "Web server", Cmd: "printf 'no newline'; exit 3", Source: source},
+		{Name: "Bad", Cmd: "x", Shell: "none aqc-no-such-program", Source: source},
+	}
+	var stdout, stderr bytes.Buffer
+	results := RunParallel(commands, &stdout, &stderr)
+
+	if !results[0].Success() || results[1].ExitCode != 3 || results[2].Err == nil {
+		t.Errorf("results = %+v", results)
+	}
+	for _, want := range []string{"Api        |" + ColorReset + " ready\n", "Web server |" + ColorReset + " no newline\n"} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("stdout does not contain %q:\n%s", want, stdout.String())
+		}
+	}
+	for _, want := range []string{"Api        |" + ColorReset + " oops\n", "exit status 3", "exit status 0"} {
+		if !strings.Contains(stderr.String(), want) {
+			t.Errorf("stderr does not contain %q:\n%s", want, stderr.String())
+		}
+	}
+}
+
+func TestRunParallelInterrupt(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh and SIGINT")
+	}
+
+	source := filepath.Join(t.TempDir(), commandsFile)
+	commands := []Command{
+		{Name: "A", Cmd: "sleep 30", Source: source},
+		{Name: "B", Cmd: "sleep 30 && echo done", Source: source},
+	}
+	// SIGINT sent to AQC itself, as kill -INT or a process manager would,
+	// reaches the commands too.
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		p, _ := os.FindProcess(os.Getpid())
+		p.Signal(os.Interrupt)
+	}()
+	start := time.Now()
+	var stdout, stderr bytes.Buffer
+	results := RunParallel(commands, &stdout, &stderr)
+
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("RunParallel() took %v after SIGINT", elapsed)
+	}
+	for i, r := range results {
+		if r.Success() || r.ExitCode != 130 {
+			t.Errorf("results[%d] = %+v, expected an interrupted command", i, r)
+		}
+	}
+}
+
+func TestRunParallelInterruptWhileStarting(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh and SIGINT")
+	}
+
+	// The first command interrupts AQC while the others are still starting;
+	// the ones that have not started by then are not started at all. The
+	// sleeps run without a shell, which could catch the signal before it
+	// starts sleep and then wait for it.
+	source := filepath.Join(t.TempDir(), commandsFile)
+	commands := []Command{{Name: "Kill", Cmd: "kill -INT $PPID", Source: source}}
+	for i := 0; i < 10; i++ {
+		commands = append(commands, Command{Name: "Sleep", Cmd: "sleep 30", Shell: directShell, Source: source})
+	}
+	start := time.Now()
+	var stdout, stderr bytes.Buffer
+	results := RunParallel(commands, &stdout, &stderr)
+
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("RunParallel() took %v after SIGINT", elapsed)
+	}
+	for i, r := range results[1:] {
+		if r.ExitCode != 130 || r.Signal != os.Interrupt {
+			t.Errorf("results[%d] = %+v, expected an interrupted command", i+1, r)
+		}
+	}
+}
+
+func TestParallelNeeds(t *testing.T) {
+	commands := []Command{
+		{Name: "Install", Cmd: "install", Source: "f", Line: 1},
+		{Name: "Api", Cmd: "api", Needs: []string{"Install"}, Source: "f", Line: 2},
+		{Name: "Web", Cmd: "web", Needs: []string{"Install", "Api"}, Source: "f", Line: 3},
+	}
+	needs, err := parallelNeeds(commands, commands[1:], map[string]string{})
+	if err != nil {
+		t.Fatalf("parallelNeeds() error = %v", err)
+	}
+	if got := planNames(needs); got != "Install" {
+		t.Errorf("parallelNeeds() = %s, expected Install once and not the selected Api", got)
+	}
+}
+
+func TestHasParallelFlag(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected bool
+	}{
+		{[]string{"api", "web", "--parallel"}, true},
+		{[]string{"-parallel", "api"}, true},
+		{[]string{"api", "--", "--parallel"}, false},
+		{[]string{"api", "--set", "x=1"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			if got := hasParallelFlag(tt.args); got != tt.expected {
+				t.Errorf("hasParallelFlag(%q) = %v, expected %v", tt.args, got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// placeholderPattern matches {{name}}, {{name=default}} and
+// {{name:choice1|choice2}}. Names must start with a letter or underscore, so
+// Go templates such as docker's {{.Name}} are left alone.
+var placeholderPattern = regexp.MustCompile(`\{\{([A-Za-z_][A-Za-z0-9_-]*)(?:(=)([^{}]*)|:([^{}]*))?\}\}`)
+
+// Placeholder is a value a command asks for before it runs.
+type Placeholder struct {
+	Name       string
+	Default    string
+	HasDefault bool
+	Choices    []string
+}
+
+// findPlaceholders returns the placeholders in cmd in order of first
+// appearance. When a name appears several times, its first occurrence defines
+// the default and choices.
+func findPlaceholders(cmd string) []Placeholder {
+	var placeholders []Placeholder
+	seen := make(map[string]bool)
+	for _, m := range placeholderPattern.FindAllStringSubmatch(cmd, -1) {
+		if seen[m[1]] {
+			continue
+		}
+		seen[m[1]] = true
+		p := Placeholder{Name: m[1]}
+		if m[2] == "=" {
+			p.Default = m[3]
+			p.HasDefault = true
+		} else if m[4] != "" {
+			p.Choices = strings.Split(m[4], "|")
+			p.Default = p.Choices[0]
+			p.HasDefault = true
+		}
+		placeholders = append(placeholders, p)
+	}
+	return placeholders
+}
+
+// validate checks that value is acceptable for the placeholder.
+func (p Placeholder) validate(value string) error {
+	if len(p.Choices) > 0 {
+		for _, choice := range p.Choices {
+			if value == choice {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s must be one of %s", p.Name, strings.Join(p.Choices, ", "))
+	}
+	if value == "" && !p.HasDefault {
+		return fmt.Errorf("a value for %s is required", p.Name)
+	}
+	return nil
+}
+
+// expandPlaceholders replaces every placeholder in cmd with its value.
+// Placeholders without a value are left untouched.
+func expandPlaceholders(cmd string, values map[string]string) string {
+	return placeholderPattern.ReplaceAllStringFunc(cmd, func(match string) string {
+		name := placeholderPattern.FindStringSubmatch(match)[1]
+		if value, ok := values[name]; ok {
+			return value
+		}
+		return match
+	})
+}
+
+// setFlags collects repeated --set name=value flags.
+type setFlags map[string]string
+
+func (s setFlags) String() string {
+	var pairs []string
+	for name, value := range s {
+		pairs = append(pairs, name+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (s setFlags) Set(pair string) error {
+	name, value, ok := strings.Cut(pair, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("expected name=value, got %q", pair)
+	}
+	s[name] = value
+	return nil
+}
+
+// applyValues fills placeholders in c from values, checking each value. With
+// useDefaults set, placeholders without a value get their default; otherwise
+// they are returned as missing.
+func applyValues(c Command, values map[string]string, useDefaults bool) (Command, []Placeholder, error) {
+	var missing []Placeholder
+	for _, p := range findPlaceholders(c.Cmd) {
+		value, ok := values[p.Name]
+		if !ok {
+			if !useDefaults || !p.HasDefault {
+				missing = append(missing, p)
+				continue
+			}
+			value = p.Default
+			values[p.Name] = value
+		}
+		if err := p.validate(value); err != nil {
+			return c, nil, err
+		}
+	}
+	if len(missing) == 0 {
+		c.Cmd = expandPlaceholders(c.Cmd, values)
+	}
+	return c, missing, nil
+}
+
+// promptPlaceholders asks in the terminal for a value for each missing
+// placeholder of c and stores the answers in values. The terminal must be in
+// raw mode. ok is false if the user cancelled.
+func promptPlaceholders(c Command, missing []Placeholder, values map[string]string, history valueHistory) bool {
+	for _, p := range missing {
+		initial := ""
+		suggestions := history[p.Name]
+		if len(p.Choices) > 0 {
+			suggestions = p.Choices
+		}
+		errMsg := ""
+		for {
+			ClearScreen()
+			PrintHeader()
+			printLine(ColorYellow + "Parameters for " + c.Name + ":" + ColorReset)
+			for _, line := range strings.Split(highlightPlaceholders(c.Cmd), "\n") {
+				printLine("  " + line)
+			}
+			printLine("")
+			if len(p.Choices) > 0 {
+				printLine(ColorBlue + "Choices: " + strings.Join(p.Choices, " | ") + "  (↑/↓ to cycle)" + ColorReset)
+			} else if len(suggestions) > 0 {
+				printLine(ColorBlue + "↑/↓ for previous values" + ColorReset)
+			}
+			if errMsg != "" {
+				printLine(ColorRed + errMsg + ColorReset)
+			}
+			printLine(ColorYellow + "Enter: accept | Esc: cancel" + ColorReset)
+
+			prompt := ColorGreen + p.Name + ColorReset + ": "
+			if p.HasDefault {
+				prompt = ColorGreen + p.Name + ColorReset + " [" + p.Default + "]: "
+			}
+			value, ok := readLine(prompt, initial, suggestions)
+			if !ok {
+				return false
+			}
+			if value == "" && p.HasDefault {
+				value = p.Default
+			}
+			if err := p.validate(value); err != nil {
+				errMsg = err.Error()
+				initial = value
+				continue
+			}
+			values[p.Name] = value
+			history.add(p.Name, value)
+			break
+		}
+	}
+	return true
+}
+
+// highlightPlaceholders colors every placeholder in cmd.
+func highlightPlaceholders(cmd string) string {
+	return placeholderPattern.ReplaceAllString(cmd, ColorPurple+"$0"+ColorReset)
+}
+
+// maxValueHistory is the number of values remembered per placeholder name.
+const maxValueHistory = 10
+
+// valueHistory remembers recently used placeholder values by name, most
+// recent first.
+type valueHistory map[string][]string
+
+// valueHistoryFile returns the path of the placeholder value history.
+func valueHistoryFile() string {
+	return filepath.Join(dataDir(), "values.json")
+}
+
+// editHistoryFile returns the path of the history of commands edited before
+// running them, by command name.
+func editHistoryFile() string {
+	return filepath.Join(dataDir(), "edits.json")
+}
+
+// loadValueHistory reads the placeholder value history. A missing or broken
+// file yields an empty history.
+func loadValueHistory() valueHistory {
+	return loadHistory(valueHistoryFile())
+}
+
+// loadHistory reads a history kept like the placeholder value history.
+func loadHistory(path string) valueHistory {
+	history := make(valueHistory)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return history
+	}
+	if err := json.Unmarshal(data, &history); err != nil {
+		return make(valueHistory)
+	}
+	return history
+}
+
+// add records value as the most recent value for name.
+func (h valueHistory) add(name, value string) {
+	values := []string{value}
+	for _, v := range h[name] {
+		if v != value && len(values) < maxValueHistory {
+			values = append(values, v)
+		}
+	}
+	h[name] = values
+}
+
+// save writes the history to disk as the placeholder value history.
+func (h valueHistory) save() error {
+	return h.saveTo(valueHistoryFile())
+}
+
+// saveTo writes the history to the file at path.
+func (h valueHistory) saveTo(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
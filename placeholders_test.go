@@ -0,0 +1,137 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindPlaceholders(t *testing.T) {
+	tests := []struct {
+		name     string
+		cmd      string
+		expected []Placeholder
+	}{
+		{
+			name:     "no placeholders",
+			cmd:      "ls -la",
+			expected: nil,
+		},
+		{
+			name:     "plain placeholder",
+			cmd:      "docker build -t {{tag}} .",
+			expected: []Placeholder{{Name: "tag"}},
+		},
+		{
+			name:     "default value",
+			cmd:      "docker build -t app:{{tag=latest}} .",
+			expected: []Placeholder{{Name: "tag", Default: "latest", HasDefault: true}},
+		},
+		{
+			name:     "empty default",
+			cmd:      "pytest {{flags=}}",
+			expected: []Placeholder{{Name: "flags", Default: "", HasDefault: true}},
+		},
+		{
+			name:     "choices",
+			cmd:      "deploy --env {{env:staging|prod}}",
+			expected: []Placeholder{{Name: "env", Default: "staging", HasDefault: true, Choices: []string{"staging", "prod"}}},
+		},
+		{
+			name:     "repeated name uses first definition",
+			cmd:      "echo {{pod}} && kubectl logs {{pod=web}}",
+			expected: []Placeholder{{Name: "pod"}},
+		},
+		{
+			name:     "go templates are not placeholders",
+			cmd:      "docker inspect -f '{{.Name}} {{ json .Config }}' {{container}}",
+			expected: []Placeholder{{Name: "container"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := findPlaceholders(tt.cmd)
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("findPlaceholders() = %+v, expected %+v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestApplyValues(t *testing.T) {
+	tests := []struct {
+		name         string
+		cmd          string
+		values       map[string]string
+		useDefaults  bool
+		expectedCmd  string
+		missingCount int
+		expectError  bool
+	}{
+		{"all values given", "kubectl logs {{pod}} -n {{ns=default}}", map[string]string{"pod": "web-1", "ns": "prod"}, false, "kubectl logs web-1 -n prod", 0, false},
+		{"defaults used", "kubectl logs {{pod}} -n {{ns=default}}", map[string]string{"pod": "web-1"}, true, "kubectl logs web-1 -n default", 0, false},
+		{"defaults not used", "kubectl logs {{pod}} -n {{ns=default}}", map[string]string{"pod": "web-1"}, false, "kubectl logs {{pod}} -n {{ns=default}}", 1, false},
+		{"missing without default", "kubectl logs {{pod}}", map[string]string{}, true, "kubectl logs {{pod}}", 1, false},
+		{"invalid choice", "deploy {{env:staging|prod}}", map[string]string{"env": "dev"}, false, "", 0, true},
+		{"valid choice", "deploy {{env:staging|prod}}", map[string]string{"env": "prod"}, false, "deploy prod", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, missing, err := applyValues(Command{Cmd: tt.cmd}, tt.values, tt.useDefaults)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("applyValues() error = %v, expectError %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+			if c.Cmd != tt.expectedCmd {
+				t.Errorf("Cmd = %q, expected %q", c.Cmd, tt.expectedCmd)
+			}
+			if len(missing) != tt.missingCount {
+				t.Errorf("missing = %v, expected %d", missing, tt.missingCount)
+			}
+		})
+	}
+}
+
+func TestSetFlags(t *testing.T) {
+	values := make(setFlags)
+	if err := values.Set("tag=v1"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := values.Set("query=a=b"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if values["tag"] != "v1" || values["query"] != "a=b" {
+		t.Errorf("values = %v", values)
+	}
+	if err := values.Set("novalue"); err == nil {
+		t.Error("Set() without '=' should fail")
+	}
+}
+
+func TestValueHistory(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	history := loadValueHistory()
+	history.add("tag", "v1")
+	history.add("tag", "v2")
+	history.add("tag", "v1")
+	if err := history.save(); err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+
+	loaded := loadValueHistory()
+	expected := []string{"v1", "v2"}
+	if !reflect.DeepEqual(loaded["tag"], expected) {
+		t.Errorf("history = %v, expected %v", loaded["tag"], expected)
+	}
+
+	for i := 0; i < maxValueHistory+5; i++ {
+		loaded.add("n", string(rune('a'+i)))
+	}
+	if len(loaded["n"]) != maxValueHistory {
+		t.Errorf("history length = %d, expected %d", len(loaded["n"]), maxValueHistory)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// span is a run of text shown in one color; color "" is the default.
+type span struct {
+	text  string
+	color string
+}
+
+// Colors of the parts of a shell command in the preview.
+const (
+	shellCommandColor  = ColorGreen
+	shellFlagColor     = ColorCyan
+	shellStringColor   = ColorYellow
+	shellVariableColor = ColorPurple
+	shellOperatorColor = ColorRed
+	shellCommentColor  = ColorBlue
+)
+
+// shellOperators are the characters that separate commands or redirect them.
+const shellOperators = "|&;<>()"
+
+// highlightShell splits cmd into spans colored like shell syntax: the
+// command names, flags, quoted strings, variables, operators and comments.
+// It only looks at the text, so anything it does not recognize stays plain.
+// Newlines are spans of their own.
+func highlightShell(cmd string) []span {
+	var spans []span
+	add := func(text, color string) {
+		spans = append(spans, span{text, color})
+	}
+	rs := []rune(cmd)
+	// commandStart is set where the next word is a command name.
+	commandStart := true
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		start := i
+		switch {
+		case r == '\n':
+			add("\n", "")
+			commandStart = true
+			i++
+		case r == ' ' || r == '\t':
+			for i < len(rs) && (rs[i] == ' ' || rs[i] == '\t') {
+				i++
+			}
+			add(string(rs[start:i]), "")
+		case r == '#' && (i == 0 || unicode.IsSpace(rs[i-1])):
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+			add(string(rs[start:i]), shellCommentColor)
+		case r == '\'' || r == '"':
+			i++
+			for i < len(rs) && rs[i] != r {
+				if r == '"' && rs[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			i = min(i+1, len(rs))
+			add(string(rs[start:i]), shellStringColor)
+			commandStart = false
+		case r == '$':
+			i++
+			switch {
+			case i < len(rs) && rs[i] == '{':
+				for i < len(rs) && rs[i] != '}' {
+					i++
+				}
+				i = min(i+1, len(rs))
+			case i < len(rs) && rs[i] == '(':
+				// $( starts a command like an operator does.
+				i++
+				add(string(rs[start:i]), shellOperatorColor)
+				commandStart = true
+				continue
+			case i < len(rs) && isNameRune(rs[i]):
+				for i < len(rs) && isNameRune(rs[i]) {
+					i++
+				}
+			case i < len(rs) && strings.ContainsRune("?!#$@*-", rs[i]):
+				i++
+			}
+			add(string(rs[start:i]), shellVariableColor)
+			commandStart = false
+		case strings.ContainsRune(shellOperators, r):
+			for i < len(rs) && strings.ContainsRune(shellOperators, rs[i]) {
+				i++
+			}
+			op := string(rs[start:i])
+			add(op, shellOperatorColor)
+			// A redirection is followed by a file name, not a command.
+			commandStart = !strings.ContainsAny(op, "<>")
+		default:
+			for i < len(rs) && !unicode.IsSpace(rs[i]) && !strings.ContainsRune(shellOperators+`'"$`, rs[i]) {
+				i++
+			}
+			word := string(rs[start:i])
+			switch {
+			case commandStart && strings.Contains(word, "="):
+				// An assignment before the command name.
+				add(word, shellVariableColor)
+			case commandStart:
+				add(word, shellCommandColor)
+				commandStart = false
+			case strings.HasPrefix(word, "-"):
+				add(word, shellFlagColor)
+			default:
+				add(word, "")
+			}
+		}
+	}
+	return spans
+}
+
+// isNameRune reports whether r can be part of a shell variable name.
+func isNameRune(r rune) bool {
+	return r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9'
+}
+
+// wrapSpans lays the spans out in lines of at most width runes, breaking
+// long lines anywhere and starting a new line at each newline. Each line is
+// indented by indent, which does not count towards width.
+func wrapSpans(spans []span, width int, indent string) []string {
+	width = max(width, 1)
+	var lines []string
+	var line, part strings.Builder
+	n := 0
+	color := ""
+	// endPart adds the text collected in the current color to the line.
+	endPart := func() {
+		if part.Len() > 0 && color != "" {
+			line.WriteString(color + part.String() + ColorReset)
+		} else {
+			line.WriteString(part.String())
+		}
+		part.Reset()
+	}
+	endLine := func() {
+		endPart()
+		lines = append(lines, indent+line.String())
+		line.Reset()
+		n = 0
+	}
+	for _, s := range spans {
+		color = s.color
+		for _, r := range s.text {
+			if r == '\n' || n == width {
+				endLine()
+			}
+			if r != '\n' {
+				part.WriteRune(r)
+				n++
+			}
+		}
+		endPart()
+	}
+	endLine()
+	return lines
+}
+
+// previewLines describes c in full for the preview pane, in lines of at most
+// width runes: the command with shell highlighting, the description, where
+// and how it runs, and the file it comes from.
+func previewLines(c Command, width int) []string {
+	const indent = "  "
+	var lines []string
+	field := func(label, value string) {
+		if value == "" {
+			return
+		}
+		lines = append(lines, ColorYellow+label+":"+ColorReset)
+		lines = append(lines, wrapSpans([]span{{value, ""}}, width-len(indent), indent)...)
+	}
+
+	cmd := highlightShell(c.Cmd)
+	if commandShell(c) == directShell {
+		cmd = []span{{c.Cmd, ""}}
+	}
+	lines = append(lines, ColorYellow+"Command:"+ColorReset)
+	lines = append(lines, wrapSpans(cmd, width-len(indent), indent)...)
+	field("Description", c.Description)
+
+	dir := "the current directory"
+	if d := workDir(c); d != "" {
+		dir = relPath(d)
+	}
+	field("Runs in", dir)
+	field("Shell", commandShell(c))
+	var env []string
+	if c.Defaults != nil {
+		env = append(append(env, c.Defaults.EnvFiles...), c.Defaults.Env...)
+	}
+	env = append(append(env, c.EnvFiles...), c.Env...)
+	field("Environment", strings.Join(env, "\n"))
+	field("Needs", strings.Join(c.Needs, ", "))
+	tags := c.Tags
+	if c.Group != "" {
+		tags = append([]string{"[" + c.Group + "]"}, tags...)
+	}
+	field("Tags", strings.Join(tags, ", "))
+	source := c.Source
+	if c.Line > 0 {
+		source += ":" + strconv.Itoa(c.Line)
+	}
+	if c.Global {
+		source += " (global)"
+	}
+	field("Source", source)
+	if reason := confirmReason(c); reason != "" {
+		lines = append(lines, ColorRed+"Asks for confirmation: "+reason+ColorReset)
+	}
+	return lines
+}
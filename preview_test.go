@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// ansi matches the color codes in rendered text.
+var ansi = regexp.MustCompile("\033\\[[0-9;]*m")
+
+func TestHighlightShell(t *testing.T) {
+	tests := []struct {
+		cmd      string
+		expected []span
+	}{
+		{
+			"ls -la | grep go",
+			[]span{{"ls", shellCommandColor}, {" ", ""}, {"-la", shellFlagColor}, {" ", ""}, {"|", shellOperatorColor},
+				{" ", ""}, {"grep", shellCommandColor}, {" ", ""}, {"go", ""}},
+		},
+		{
+			`NODE_ENV=prod echo "hi $USER" > out.txt`,
+			[]span{{"NODE_ENV=prod", shellVariableColor}, {" ", ""}, {"echo", shellCommandColor}, {" ", ""}, {`"hi $USER"`, shellStringColor},
+				{" ", ""}, {">", shellOperatorColor}, {" ", ""}, {"out.txt", ""}},
+		},
+		{
+			"cd $(git rev-parse --show-toplevel) # root\nmake",
+			[]span{{"cd", shellCommandColor}, {" ", ""}, {"$(", shellOperatorColor}, {"git", shellCommandColor}, {" ", ""}, {"rev-parse", ""},
+				{" ", ""}, {"--show-toplevel", shellFlagColor}, {")", shellOperatorColor}, {" ", ""}, {"# root", shellCommentColor},
+				{"\n", ""}, {"make", shellCommandColor}},
+		},
+		{
+			"echo ${HOME}/x 'it''s'",
+			[]span{{"echo", shellCommandColor}, {" ", ""}, {"${HOME}", shellVariableColor}, {"/x", ""}, {" ", ""},
+				{"'it'", shellStringColor}, {"'s'", shellStringColor}},
+		},
+	}
+	for _, tt := range tests {
+		if got := highlightShell(tt.cmd); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("highlightShell(%q) =\n%q\nexpected\n%q", tt.cmd, got, tt.expected)
+		}
+	}
+}
+
+func TestWrapSpans(t *testing.T) {
+	spans := []span{{"make", shellCommandColor}, {" build-all\nok", ""}}
+	lines := wrapSpans(spans, 6, "  ")
+	var plain []string
+	for _, line := range lines {
+		plain = append(plain, ansi.ReplaceAllString(line, ""))
+	}
+	if expected := []string{"  make b", "  uild-a", "  ll", "  ok"}; !reflect.DeepEqual(plain, expected) {
+		t.Errorf("wrapSpans() = %q, expected %q", plain, expected)
+	}
+	if !strings.HasPrefix(lines[0], "  "+shellCommandColor+"make"+ColorReset) {
+		t.Errorf("The first line lost its colors: %q", lines[0])
+	}
+}
+
+func TestPreviewLines(t *testing.T) {
+	c := Command{
+		Name:        "Deploy",
+		Description: "Build the image and roll it out to the production cluster",
+		Cmd:         "docker build -t app . && kubectl apply -f k8s/",
+		Env:         []string{"KUBECONFIG=~/.kube/prod"},
+		Needs:       []string{"Test"},
+		Group:       "deploy",
+		Source:      "/work/.commands.aqc",
+		Line:        12,
+	}
+	lines := previewLines(c, 30)
+	text := ansi.ReplaceAllString(strings.Join(lines, "\n"), "")
+	for _, s := range []string{"Command:", "docker build", "production", "Runs in:", "Environment:\n  KUBECONFIG=~/.kube/prod", "Needs:\n  Test", "[deploy]", ".commands.aqc:12"} {
+		if !strings.Contains(text, s) {
+			t.Errorf("The preview does not show %q:\n%s", s, text)
+		}
+	}
+	for _, line := range lines {
+		if n := utf8.RuneCountInString(ansi.ReplaceAllString(line, "")); n > 30 {
+			t.Errorf("Line %q is %d runes wide, expected at most 30", line, n)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// ownProcessGroup does nothing on Windows, where Ctrl+C in the console
+// reaches every process attached to it.
+func ownProcessGroup(cmd *exec.Cmd) {}
+
+// signalGroup sends sig to cmd; Windows has no process groups to signal.
+func signalGroup(cmd *exec.Cmd, sig os.Signal) error {
+	return cmd.Process.Signal(sig)
+}
@@ -0,0 +1,9 @@
+//go:build windows
+
+package main
+
+// onResize does nothing on Windows, which has no resize signal; the menu
+// measures the terminal again after every key instead.
+func onResize(redraw func()) (stop func()) {
+	return func() {}
+}
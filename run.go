@@ -0,0 +1,206 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// RunSubcommand handles the "run" subcommand. It runs a saved command by name
+// or number without the interactive menu, after the commands it needs, and
+// exits with the status of the last command that ran. With --parallel it
+// runs every named command at the same time instead.
+func RunSubcommand() {
+	runCmd := flag.NewFlagSet("run", flag.ExitOnError)
+	values := make(setFlags)
+	runCmd.Var(values, "set", "Set a placeholder value as name=value (repeatable)")
+	runCmd.Bool("parallel", false, "Run all the named commands at the same time, prefixing their output with their names")
+	runCmd.BoolVar(&assumeYes, "yes", false, "Run commands that need confirmation without asking")
+	runCmd.Usage = func() {
+		fmt.Fprintln(runCmd.Output(), "Usage: aqc run [--set name=value] [--yes] <name|number> [-- extra args]")
+		fmt.Fprintln(runCmd.Output(), "       aqc run --parallel [--set name=value] [--yes] <name|number>...")
+		runCmd.PrintDefaults()
+	}
+	if hasParallelFlag(os.Args[2:]) {
+		os.Exit(runParallelSubcommand(runCmd, values))
+	}
+	query := parseTarget(runCmd)
+	// Everything after the name, or after "--", is passed on.
+	extra := runCmd.Args()
+
+	commands, _ := LoadCommands()
+	index, err := findCommand(commands, query)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sError: %v%s\n", ColorRed, err, ColorReset)
+		os.Exit(1)
+	}
+
+	selected, missing, err := applyValues(commands[index], values, true)
+	if err == nil && len(missing) > 0 {
+		err = fmt.Errorf("no value for {{%s}}; pass --set %s=<value>", missing[0].Name, missing[0].Name)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sError: %v%s\n", ColorRed, err, ColorReset)
+		os.Exit(1)
+	}
+	selected.Cmd = appendArgs(selected.Cmd, extra)
+	steps, err := prepareNeeds(commands, selected, values)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sError: %v%s\n", ColorRed, err, ColorReset)
+		os.Exit(1)
+	}
+
+	steps = append(steps, selected)
+	if !confirmRun(steps) {
+		os.Exit(1)
+	}
+	result := runSteps(steps, false)
+	if result.Err != nil {
+		fmt.Fprintf(os.Stderr, "%sError executing command: %v%s\n", ColorRed, result.Err, ColorReset)
+	}
+	os.Exit(result.ExitCode)
+}
+
+// hasParallelFlag reports whether args ask for a parallel run before any "--".
+func hasParallelFlag(args []string) bool {
+	for _, arg := range args {
+		switch arg {
+		case "--":
+			return false
+		case "-parallel", "--parallel", "-parallel=true", "--parallel=true":
+			return true
+		}
+	}
+	return false
+}
+
+// runParallelSubcommand runs the commands named in the arguments of "aqc run
+// --parallel", where flags and names may be mixed, and returns the exit
+// status.
+func runParallelSubcommand(fs *flag.FlagSet, values setFlags) int {
+	var queries []string
+	for args := os.Args[2:]; ; {
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			break
+		}
+		queries = append(queries, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(queries) == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	commands, _ := LoadCommands()
+	var selected []Command
+	for _, query := range queries {
+		index, err := findCommand(commands, query)
+		if err == nil {
+			var c Command
+			var missing []Placeholder
+			c, missing, err = applyValues(commands[index], values, true)
+			if err == nil && len(missing) > 0 {
+				err = fmt.Errorf("%q needs a value for {{%s}}; pass --set %s=<value>", c.Name, missing[0].Name, missing[0].Name)
+			}
+			selected = append(selected, c)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%sError: %v%s\n", ColorRed, err, ColorReset)
+			return 1
+		}
+	}
+	needs, err := parallelNeeds(commands, selected, values)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sError: %v%s\n", ColorRed, err, ColorReset)
+		return 1
+	}
+	if !confirmRun(append(append([]Command(nil), needs...), selected...)) {
+		return 1
+	}
+	return runParallelSteps(needs, selected)
+}
+
+// confirmRun asks for confirmation of the steps that need it, as
+// confirmSteps does, and reports on stderr why the steps will not run. It
+// returns false when they must not run.
+func confirmRun(steps []Command) bool {
+	var restore func()
+	ok, err := confirmSteps(steps, &restore)
+	if restore != nil {
+		restore()
+	}
+	switch {
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "%sError: %v%s\n", ColorRed, err, ColorReset)
+	case !ok:
+		fmt.Fprintln(os.Stderr, ColorYellow+"Cancelled."+ColorReset)
+	}
+	return ok && err == nil
+}
+
+// findCommand returns the index of the command matching query: a 1-based
+// number, an exact name, or a prefix that matches exactly one name. Name
+// matching ignores case when there is no exact match.
+func findCommand(commands []Command, query string) (int, error) {
+	if num, err := strconv.Atoi(query); err == nil {
+		if num < 1 || num > len(commands) {
+			return -1, fmt.Errorf("no command number %d (there are %d)", num, len(commands))
+		}
+		return num - 1, nil
+	}
+	for i, c := range commands {
+		if c.Name == query {
+			return i, nil
+		}
+	}
+	var matches []int
+	for i, c := range commands {
+		if strings.EqualFold(c.Name, query) {
+			return i, nil
+		}
+		if strings.HasPrefix(strings.ToLower(c.Name), strings.ToLower(query)) {
+			matches = append(matches, i)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return -1, fmt.Errorf("no command named %q", query)
+	case 1:
+		return matches[0], nil
+	}
+	var names []string
+	for _, i := range matches {
+		names = append(names, strconv.Quote(commands[i].Name))
+	}
+	return -1, fmt.Errorf("%q matches several commands: %s", query, strings.Join(names, ", "))
+}
+
+// appendArgs appends shell-quoted args to the command text.
+func appendArgs(cmd string, args []string) string {
+	for _, arg := range args {
+		cmd += " " + shellQuote(arg)
+	}
+	return cmd
+}
+
+// shellQuote quotes s for a POSIX shell, leaving it as is when it only
+// contains characters the shell does not interpret.
+func shellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	safe := true
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./=:,+@%", r)) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
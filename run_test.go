@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestFindCommand(t *testing.T) {
+	commands := []Command{
+		{Name: "Build"},
+		{Name: "Build Docker"},
+		{Name: "Test"},
+		{Name: "Deploy Staging"},
+		{Name: "Deploy Prod"},
+	}
+
+	tests := []struct {
+		name        string
+		query       string
+		expected    int
+		expectError bool
+	}{
+		{"number", "3", 2, false},
+		{"number out of range", "6", -1, true},
+		{"zero", "0", -1, true},
+		{"exact name beats prefix", "Build", 0, false},
+		{"case-insensitive name", "test", 2, false},
+		{"unique prefix", "build d", 1, false},
+		{"ambiguous prefix", "Deploy", -1, true},
+		{"no match", "Lint", -1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index, err := findCommand(commands, tt.query)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("findCommand(%q) error = %v, expectError %v", tt.query, err, tt.expectError)
+			}
+			if !tt.expectError && index != tt.expected {
+				t.Errorf("findCommand(%q) = %d, expected %d", tt.query, index, tt.expected)
+			}
+		})
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"--verbose", "--verbose"},
+		{"path/to/file.txt", "path/to/file.txt"},
+		{"", "''"},
+		{"hello world", "'hello world'"},
+		{"it's", `'it'\''s'`},
+		{"$HOME", "'$HOME'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if result := shellQuote(tt.input); result != tt.expected {
+				t.Errorf("shellQuote(%q) = %q, expected %q", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAppendArgs(t *testing.T) {
+	result := appendArgs("npm test", []string{"--", "-t", "my test"})
+	expected := "npm test -- -t 'my test'"
+	if result != expected {
+		t.Errorf("appendArgs() = %q, expected %q", result, expected)
+	}
+	if result := appendArgs("ls", nil); result != "ls" {
+		t.Errorf("appendArgs() without args = %q, expected %q", result, "ls")
+	}
+}
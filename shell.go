@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// shellEnv is the environment variable that sets the shell for commands
+// whose file does not choose one.
+const shellEnv = "AQC_SHELL"
+
+// defaultShell runs commands when neither the command, its file nor
+// $AQC_SHELL chooses a shell.
+const defaultShell = "sh"
+
+// directShell is the shell value that runs a command without a shell: the
+// command is split into words and the first one is executed directly.
+const directShell = "none"
+
+// cmdMarker stands for the command text in a shell template.
+const cmdMarker = "{cmd}"
+
+// knownShells maps the names of common shells and interpreters to the
+// arguments that make them run a command given as text.
+var knownShells = map[string][]string{
+	"sh":         {"-c", cmdMarker},
+	"bash":       {"-c", cmdMarker},
+	"zsh":        {"-c", cmdMarker},
+	"fish":       {"-c", cmdMarker},
+	"dash":       {"-c", cmdMarker},
+	"python":     {"-c", cmdMarker},
+	"python3":    {"-c", cmdMarker},
+	"node":       {"-e", cmdMarker},
+	"ruby":       {"-e", cmdMarker},
+	"perl":       {"-e", cmdMarker},
+	"pwsh":       {"-NoProfile", "-Command", cmdMarker},
+	"powershell": {"-NoProfile", "-Command", cmdMarker},
+}
+
+// commandShell returns the shell value c runs with: its own shell attribute,
+// the one of its file's @defaults block, $AQC_SHELL, or sh.
+func commandShell(c Command) string {
+	switch {
+	case c.Shell != "":
+		return c.Shell
+	case c.Defaults != nil && c.Defaults.Shell != "":
+		return c.Defaults.Shell
+	case os.Getenv(shellEnv) != "":
+		return os.Getenv(shellEnv)
+	}
+	return defaultShell
+}
+
+// commandArgv returns the program and arguments that run c. Environment
+// variables in the shell value are expanded first, so "shell: $SHELL" picks
+// the user's login shell.
+func commandArgv(c Command) ([]string, error) {
+	shell := os.ExpandEnv(commandShell(c))
+	if shell == directShell {
+		words, err := splitWords(c.Cmd)
+		if err != nil {
+			return nil, err
+		}
+		if len(words) == 0 {
+			return nil, fmt.Errorf("nothing to run")
+		}
+		return words, nil
+	}
+	template, err := shellTemplate(shell)
+	if err != nil {
+		return nil, err
+	}
+	argv := make([]string, 0, len(template)+1)
+	found := false
+	for _, word := range template {
+		if strings.Contains(word, cmdMarker) {
+			word = strings.ReplaceAll(word, cmdMarker, c.Cmd)
+			found = true
+		}
+		argv = append(argv, word)
+	}
+	if !found {
+		argv = append(argv, c.Cmd)
+	}
+	return argv, nil
+}
+
+// shellTemplate returns the arguments that run a command with shell. A single
+// word names a program; known shells get their usual arguments and any other
+// program is given -c, like sh. Several words are used as they are, with
+// {cmd} replaced by the command or, without {cmd}, the command added last.
+func shellTemplate(shell string) ([]string, error) {
+	words, err := splitWords(shell)
+	if err != nil {
+		return nil, fmt.Errorf("shell %q: %v", shell, err)
+	}
+	switch len(words) {
+	case 0:
+		return nil, fmt.Errorf("empty shell")
+	case 1:
+		args, ok := knownShells[strings.TrimSuffix(filepath.Base(words[0]), ".exe")]
+		if !ok {
+			args = knownShells[defaultShell]
+		}
+		return append(words, args...), nil
+	}
+	return words, nil
+}
+
+// checkShell reports whether shell can be used as the value of a shell
+// attribute.
+func checkShell(shell string) error {
+	if shell == directShell {
+		return nil
+	}
+	_, err := shellTemplate(shell)
+	return err
+}
+
+// splitWords splits s into words the way a POSIX shell does, without any
+// expansion: words are separated by white space, single quotes keep text as
+// it is, double quotes keep white space and allow \" and \\, and a backslash
+// outside quotes escapes the next character.
+func splitWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+			continue
+		case ch == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated ' quote")
+			}
+			word.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+		case ch == '"':
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("\"\\$`", s[i+1]) >= 0 {
+					i++
+				}
+				word.WriteByte(s[i])
+			}
+			if i == len(s) {
+				return nil, fmt.Errorf("unterminated \" quote")
+			}
+		case ch == '\\' && i+1 < len(s):
+			i++
+			word.WriteByte(s[i])
+		default:
+			word.WriteByte(ch)
+		}
+		inWord = true
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
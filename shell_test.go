@@ -0,0 +1,119 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+		wantErr  bool
+	}{
+		{"", nil, false},
+		{"  ls   -la ", []string{"ls", "-la"}, false},
+		{`rm "a file.txt" 'it''s'`, []string{"rm", "a file.txt", "its"}, false},
+		{`echo "say \"hi\" \n" a\ b`, []string{"echo", `say "hi" \n`, "a b"}, false},
+		{`echo '$HOME' "$HOME"`, []string{"echo", "$HOME", "$HOME"}, false},
+		{`x""`, []string{"x"}, false},
+		{`''`, []string{""}, false},
+		{`echo 'open`, nil, true},
+		{`echo "open`, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := splitWords(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("splitWords(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("splitWords(%q) = %q, expected %q", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCommandArgv(t *testing.T) {
+	t.Setenv(shellEnv, "")
+	t.Setenv("AQC_TEST_SHELL", "/usr/bin/zsh")
+
+	tests := []struct {
+		name     string
+		command  Command
+		expected []string
+	}{
+		{"default", Command{Cmd: "ls"}, []string{"sh", "-c", "ls"}},
+		{"known shell", Command{Cmd: "ls", Shell: "bash"}, []string{"bash", "-c", "ls"}},
+		{"interpreter", Command{Cmd: "print(1)", Shell: "python3"}, []string{"python3", "-c", "print(1)"}},
+		{"powershell", Command{Cmd: "dir", Shell: "pwsh"}, []string{"pwsh", "-NoProfile", "-Command", "dir"}},
+		{"path to a known shell", Command{Cmd: "ls", Shell: "$AQC_TEST_SHELL"}, []string{"/usr/bin/zsh", "-c", "ls"}},
+		{"unknown program", Command{Cmd: "ls", Shell: "ksh"}, []string{"ksh", "-c", "ls"}},
+		{"template", Command{Cmd: "ls", Shell: "bash -eu -c"}, []string{"bash", "-eu", "-c", "ls"}},
+		{"template with marker", Command{Cmd: "ls", Shell: "ssh host {cmd} --"}, []string{"ssh", "host", "ls", "--"}},
+		{"direct", Command{Cmd: `rm "a b" c`, Shell: "none"}, []string{"rm", "a b", "c"}},
+		{"file default", Command{Cmd: "ls", Defaults: &Defaults{Shell: "fish"}}, []string{"fish", "-c", "ls"}},
+		{"command beats file", Command{Cmd: "ls", Shell: "bash", Defaults: &Defaults{Shell: "fish"}}, []string{"bash", "-c", "ls"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := commandArgv(tt.command)
+			if err != nil {
+				t.Fatalf("commandArgv() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("commandArgv() = %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+
+	t.Run("AQC_SHELL", func(t *testing.T) {
+		t.Setenv(shellEnv, "zsh")
+		if got, _ := commandArgv(Command{Cmd: "ls"}); !reflect.DeepEqual(got, []string{"zsh", "-c", "ls"}) {
+			t.Errorf("commandArgv() = %q, expected zsh", got)
+		}
+	})
+	t.Run("nothing to run", func(t *testing.T) {
+		if _, err := commandArgv(Command{Cmd: "  ", Shell: "none"}); err == nil {
+			t.Error("commandArgv() of an empty direct command succeeded")
+		}
+	})
+}
+
+func TestShellAttribute(t *testing.T) {
+	content := "#aqc v2\n@defaults\nshell: bash\n---\nls\n- List\nshell: none\n---\n"
+	commands, diags := ParseCommandFile("f", content)
+	if len(diags) != 0 || len(commands) != 1 {
+		t.Fatalf("ParseCommandFile() = %+v, %v", commands, diags)
+	}
+	if commands[0].Shell != "none" || commands[0].Defaults.Shell != "bash" {
+		t.Errorf("Shell = %q, defaults %+v", commands[0].Shell, commands[0].Defaults)
+	}
+	if _, diags := ParseCommandFile("f", "#aqc v2\nls\n- List\nshell: bash 'open\n"); len(diags) != 1 {
+		t.Errorf("A bad shell gave diagnostics %v, expected one", diags)
+	}
+}
+
+func TestRunCommandUsesShell(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, commandsFile)
+	// Without a shell, the $ and > reach touch as they are.
+	if result := RunCommand(Command{Cmd: "touch '$X>y'", Shell: "none", Source: source}); !result.Success() {
+		t.Fatalf("RunCommand() = %s", result)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "$X>y")); err != nil {
+		t.Errorf("Direct command was interpreted by a shell: %v", err)
+	}
+	if result := RunCommand(Command{Cmd: "true", Shell: "aqc-no-such-shell", Source: source}); result.Err == nil {
+		t.Errorf("RunCommand() with a missing shell = %s, expected an error", result)
+	}
+}
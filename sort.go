@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// SortSubcommand handles the "sort" subcommand to order the commands of a
+// file by name.
+func SortSubcommand() {
+	sortCmd := flag.NewFlagSet("sort", flag.ExitOnError)
+	globalPtr := sortCmd.Bool("global", false, "Sort the global commands file")
+	sortCmd.Usage = func() {
+		fmt.Fprintln(sortCmd.Output(), "Usage: aqc sort [--global] [file]")
+		sortCmd.PrintDefaults()
+	}
+	sortCmd.Parse(os.Args[2:])
+
+	path := sortCmd.Arg(0)
+	switch {
+	case *globalPtr:
+		path = globalCommandsFile()
+	case path == "":
+		cwd, err := os.Getwd()
+		if err != nil {
+			fmt.Printf("%sError getting current directory: %v%s\n", ColorRed, err, ColorReset)
+			os.Exit(1)
+		}
+		files := projectCommandFiles(cwd)
+		if len(files) == 0 {
+			fmt.Println(ColorRed + "Error: no " + commandsFile + " file found." + ColorReset)
+			os.Exit(1)
+		}
+		path = files[0]
+	}
+
+	count, err := SortFile(path)
+	if err != nil {
+		fmt.Printf("%sError sorting %s: %v%s\n", ColorRed, path, err, ColorReset)
+		os.Exit(1)
+	}
+	fmt.Printf("%sSorted %d commands in %s.%s\n", ColorGreen, count, relPath(path), ColorReset)
+}
+
+// SortFile orders the commands of the file at path by name and returns how
+// many there are. Files in the block format keep their comments and spacing.
+func SortFile(path string) (int, error) {
+	if formatForPath(path).name != "aqc" {
+		commands, err := readEntries(path)
+		if err != nil {
+			return 0, err
+		}
+		sort.SliceStable(commands, func(i, j int) bool {
+			return byName(commands[i], commands[j])
+		})
+		return len(commands), writeEntries(path, commands)
+	}
+	doc, err := ReadDocument(path)
+	if err != nil {
+		return 0, err
+	}
+	doc.Sort(byName)
+	return len(doc.Commands()), doc.Save()
+}
+
+// byName orders commands by name, ignoring case.
+func byName(a, b Command) bool {
+	return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+}